@@ -0,0 +1,44 @@
+package capture
+
+import (
+	"fmt"
+
+	"m1k1o/neko/internal/capture/gst"
+	"m1k1o/neko/internal/types"
+	"m1k1o/neko/internal/types/codec"
+)
+
+// returns the most preferred aac encoder element available, webrtc clients
+// do not support aac, it is only used by broadcast and hls outputs
+func aacEncoder() (string, error) {
+	if name, ok := PreferredEncoder(codec.AAC()); ok {
+		return name, nil
+	}
+
+	// avenc_aac - gstreamer1.0-libav
+	// faac, voaacenc - gstreamer1.0-plugins-bad
+	return "", fmt.Errorf("%w: no aac encoder found, install gstreamer1.0-libav (avenc_aac) or faac", types.ErrPipelineResource)
+}
+
+// returns part of pipeline transcoding raw audio to parsed aac, e.g. opus
+// capture for rtmp or hls outputs. bitrate in kbit/s, zero keeps encoder default.
+func NewAACPipeline(name string, bitrate uint) (string, error) {
+	encoder, err := aacEncoder()
+	if err != nil {
+		return "", err
+	}
+
+	if err := gst.CheckPlugins([]string{"audioparsers"}); err != nil {
+		return "", err
+	}
+
+	pipelineStr := "audioconvert ! audioresample ! " + encoder
+	if name != "" {
+		pipelineStr += " name=" + name
+	}
+	if bitrate > 0 {
+		pipelineStr += fmt.Sprintf(" bitrate=%d", bitrate*1000)
+	}
+
+	return pipelineStr + " ! aacparse", nil
+}
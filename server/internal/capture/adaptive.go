@@ -0,0 +1,164 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// changes are applied at most once per interval, so that encoder is not thrashed
+	adaptiveDebounce = 2 * time.Second
+	// share of estimated bandwidth used by the stream, rest is headroom
+	adaptiveBandwidthShare = 0.9
+	// smaller bitrate changes are ignored
+	adaptiveBitrateThreshold = 0.1
+)
+
+type adaptiveLimits struct {
+	minFramerate int16
+	maxFramerate int16
+	minBitrate   int // kbit/s
+	maxBitrate   int // kbit/s
+}
+
+type adaptiveParams struct {
+	framerateStep int16         // largest framerate change at once, zero is unlimited
+	cooldown      time.Duration // zero means adaptiveDebounce
+}
+
+// sets how aggressively SetTargetBandwidth adapts, framerate changes by at most
+// framerateStep at once and changes are applied at most once per cooldown. zero
+// step is unlimited, zero cooldown restores the default of 2s.
+func (manager *StreamSinkManagerCtx) SetAdaptiveParams(framerateStep int16, cooldown time.Duration) error {
+	if framerateStep < 0 {
+		return fmt.Errorf("invalid framerate step %d, must not be negative", framerateStep)
+	}
+
+	if cooldown < 0 {
+		return fmt.Errorf("invalid cooldown %s, must not be negative", cooldown)
+	}
+
+	manager.adaptiveMu.Lock()
+	defer manager.adaptiveMu.Unlock()
+
+	manager.adaptiveParams = adaptiveParams{
+		framerateStep: framerateStep,
+		cooldown:      cooldown,
+	}
+
+	return nil
+}
+
+// sets bounds within which framerate and bitrate are picked by SetTargetBandwidth
+func (manager *StreamSinkManagerCtx) SetAdaptiveLimits(minFramerate, maxFramerate int16, minBitrate, maxBitrate int) error {
+	if minFramerate <= 0 || maxFramerate < minFramerate {
+		return fmt.Errorf("invalid framerate limits %d-%d", minFramerate, maxFramerate)
+	}
+
+	if minBitrate <= 0 || maxBitrate < minBitrate {
+		return fmt.Errorf("invalid bitrate limits %d-%d kbit/s", minBitrate, maxBitrate)
+	}
+
+	manager.adaptiveMu.Lock()
+	defer manager.adaptiveMu.Unlock()
+
+	manager.adaptiveLimits = adaptiveLimits{
+		minFramerate: minFramerate,
+		maxFramerate: maxFramerate,
+		minBitrate:   minBitrate,
+		maxBitrate:   maxBitrate,
+	}
+
+	return nil
+}
+
+// picks bitrate and framerate for bandwidth estimated by the receiver, e.g. from
+// REMB or transport-cc feedback. framerate is lowered proportionally to bitrate,
+// it is applied only if adaptive framerate is enabled.
+func (manager *StreamSinkManagerCtx) SetTargetBandwidth(bps int) error {
+	if bps <= 0 {
+		return fmt.Errorf("invalid bandwidth %d, must be a positive number of bit/s", bps)
+	}
+
+	manager.adaptiveMu.Lock()
+	defer manager.adaptiveMu.Unlock()
+
+	limits := manager.adaptiveLimits
+	if limits.maxBitrate == 0 {
+		return errors.New("adaptive limits are not set")
+	}
+
+	bitrate := int(float64(bps) * adaptiveBandwidthShare / 1000)
+	if bitrate < limits.minBitrate {
+		bitrate = limits.minBitrate
+	}
+	if bitrate > limits.maxBitrate {
+		bitrate = limits.maxBitrate
+	}
+
+	framerate := int16(int(limits.maxFramerate) * bitrate / limits.maxBitrate)
+	if framerate < limits.minFramerate {
+		framerate = limits.minFramerate
+	}
+
+	// move towards target gradually, so that framerate does not oscillate
+	params := manager.adaptiveParams
+	if step := params.framerateStep; step > 0 && manager.adaptiveFramerateValue > 0 {
+		if framerate > manager.adaptiveFramerateValue+step {
+			framerate = manager.adaptiveFramerateValue + step
+		}
+		if framerate < manager.adaptiveFramerateValue-step {
+			framerate = manager.adaptiveFramerateValue - step
+		}
+	}
+
+	// framerate bounds take precedence over adaptive limits
+	manager.pipelineMu.Lock()
+	clamped := manager.clampFramerate(framerate)
+	manager.pipelineMu.Unlock()
+
+	cooldown := params.cooldown
+	if cooldown == 0 {
+		cooldown = adaptiveDebounce
+	}
+
+	if time.Since(manager.adaptiveChangedAt) < cooldown {
+		return nil
+	}
+
+	bitrateDiff := float64(bitrate-manager.adaptiveBitrate) / float64(limits.maxBitrate)
+	if bitrateDiff < 0 {
+		bitrateDiff = -bitrateDiff
+	}
+
+	if bitrateDiff < adaptiveBitrateThreshold && clamped == manager.adaptiveFramerateValue {
+		return nil
+	}
+
+	// logged only when applied, bandwidth is reported often
+	if clamped != framerate {
+		manager.logger().Debug().
+			Int16("requested", framerate).
+			Int16("framerate", clamped).
+			Msg("adaptive framerate is out of bounds, clamping it")
+		framerate = clamped
+	}
+
+	manager.logger().Debug().
+		Int("bandwidth", bps).
+		Int("bitrate", bitrate).
+		Int16("framerate", framerate).
+		Msg("adapting to bandwidth")
+
+	if err := manager.SetBitrate(bitrate); err != nil {
+		return err
+	}
+
+	manager.SetChangeFramerate(framerate)
+
+	manager.adaptiveBitrate = bitrate
+	manager.adaptiveFramerateValue = framerate
+	manager.adaptiveChangedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,64 @@
+package capture
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"m1k1o/neko/internal/types"
+)
+
+// checks that pulseaudio source or monitor with given name exists
+func pulseSourceExists(name string) bool {
+	// TODO: Use native API.
+	cmd := exec.Command("pactl", "list", "short", "sources")
+	res, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+
+	// lines are: index, name, driver, sample spec, state
+	for _, line := range strings.Split(string(res), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// captures audio from given pulseaudio source, e.g. monitor of a virtual sink
+// used for mixing, instead of the configured device. empty name restores the
+// configured device. source is kept when pipeline is recreated.
+func (manager *StreamSinkManagerCtx) SetAudioSource(name string) error {
+	if rtpCodec := manager.Codec(); !rtpCodec.IsAudio() {
+		return fmt.Errorf("unable to set audio source of %s stream", rtpCodec.Name)
+	}
+
+	if name != "" && manager.audioSourceExistsFn != nil && !manager.audioSourceExistsFn(name) {
+		return fmt.Errorf("%w: audio source %s does not exist", types.ErrPipelineResource, name)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.audioSource == name {
+		return nil
+	}
+
+	manager.audioSource = name
+	manager.logger().Info().Str("audio_source", name).Msgf("setting audio source")
+
+	// source properties are read only when it starts
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+func (manager *StreamSinkManagerCtx) AudioSource() string {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.audioSource
+}
@@ -0,0 +1,269 @@
+package capture
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"m1k1o/neko/internal/types"
+)
+
+const (
+	// default time a sample waits for sample of the other stream before it is emitted anyway
+	avMuxDefaultWindow = 500 * time.Millisecond
+	// samples queued per stream, oldest is emitted when it is full
+	avMuxMaxQueue = 256
+	// size of combined sample buffer, samples are dropped when it is full
+	avMuxBufferSize = 256
+)
+
+// sample of combined stream, tagged with the stream it comes from. receiver
+// owns it and must release it, see types.Sample.Release
+type AVSample struct {
+	types.Sample
+	Video bool
+	// presentation time aligned across both streams
+	Time time.Time
+}
+
+type avQueue struct {
+	samples []AVSample
+	// wall clock time of zero pts, pipelines start their pts at different times
+	base    time.Time
+	lastPTS time.Duration
+}
+
+// aligns sample to wall clock using its pts, base is set from the first sample and
+// again when pts goes back, e.g. because pipeline was recreated
+func (queue *avQueue) push(sample types.Sample, video bool) {
+	aligned := AVSample{Sample: sample, Video: video, Time: sample.Timestamp}
+
+	if sample.PTS >= 0 {
+		if queue.base.IsZero() || sample.PTS < queue.lastPTS {
+			queue.base = sample.Timestamp.Add(-sample.PTS)
+		}
+
+		queue.lastPTS = sample.PTS
+		aligned.Time = queue.base.Add(sample.PTS)
+	}
+
+	queue.samples = append(queue.samples, aligned)
+}
+
+func (queue *avQueue) pop() AVSample {
+	sample := queue.samples[0]
+	queue.samples = queue.samples[1:]
+	return sample
+}
+
+// pairs audio and video stream sinks into a single stream of samples ordered by
+// presentation time. while one stream has no samples, e.g. because it started
+// later, samples of the other one wait at most window and are then emitted alone.
+type AVMuxerCtx struct {
+	id     string
+	logger zerolog.Logger
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+
+	audio  types.StreamSinkManager
+	video  types.StreamSinkManager
+	window time.Duration
+
+	audioSamples <-chan types.Sample
+	videoSamples <-chan types.Sample
+
+	samples        chan AVSample
+	droppedSamples atomic.Uint64
+}
+
+// window is how long samples wait for the other stream, zero for default
+func NewAVMuxer(id string, audio types.StreamSinkManager, video types.StreamSinkManager, window time.Duration) *AVMuxerCtx {
+	if window <= 0 {
+		window = avMuxDefaultWindow
+	}
+
+	return &AVMuxerCtx{
+		id: id,
+		logger: log.With().
+			Str("module", "capture").
+			Str("submodule", "av-muxer").
+			Str("muxer_id", id).Logger(),
+		audio:   audio,
+		video:   video,
+		window:  window,
+		samples: make(chan AVSample, avMuxBufferSize),
+	}
+}
+
+// combined samples, channel is closed when muxer is stopped
+func (muxer *AVMuxerCtx) Samples() <-chan AVSample {
+	return muxer.samples
+}
+
+func (muxer *AVMuxerCtx) DroppedSamples() uint64 {
+	return muxer.droppedSamples.Load()
+}
+
+// subscribes to both streams and starts them, they are kept running until Stop
+func (muxer *AVMuxerCtx) Start() error {
+	muxer.mu.Lock()
+	defer muxer.mu.Unlock()
+
+	if muxer.cancel != nil {
+		return nil
+	}
+
+	audioSamples, err := muxer.audio.Subscribe(muxer.id)
+	if err != nil {
+		return err
+	}
+
+	videoSamples, err := muxer.video.Subscribe(muxer.id)
+	if err != nil {
+		muxer.audio.Unsubscribe(muxer.id)
+		return err
+	}
+
+	if err := muxer.audio.AddListener(); err != nil {
+		muxer.audio.Unsubscribe(muxer.id)
+		muxer.video.Unsubscribe(muxer.id)
+		return err
+	}
+
+	if err := muxer.video.AddListener(); err != nil {
+		_ = muxer.audio.RemoveListener()
+		muxer.audio.Unsubscribe(muxer.id)
+		muxer.video.Unsubscribe(muxer.id)
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	muxer.cancel = cancel
+	muxer.audioSamples = audioSamples
+	muxer.videoSamples = videoSamples
+
+	muxer.wg.Add(1)
+	go func() {
+		defer muxer.wg.Done()
+		muxer.mux(ctx, audioSamples, videoSamples)
+	}()
+
+	muxer.logger.Info().Dur("window", muxer.window).Msgf("started")
+	return nil
+}
+
+// stops both streams and closes samples channel, muxer cannot be started again
+func (muxer *AVMuxerCtx) Stop() {
+	muxer.mu.Lock()
+	defer muxer.mu.Unlock()
+
+	if muxer.cancel == nil {
+		return
+	}
+
+	muxer.cancel()
+	muxer.wg.Wait()
+
+	muxer.audio.Unsubscribe(muxer.id)
+	muxer.video.Unsubscribe(muxer.id)
+
+	// channels are closed by unsubscribing, samples left in them are not emitted
+	for sample := range muxer.audioSamples {
+		sample.Release()
+	}
+	for sample := range muxer.videoSamples {
+		sample.Release()
+	}
+
+	if err := muxer.audio.RemoveListener(); err != nil {
+		muxer.logger.Warn().Err(err).Msg("unable to remove audio listener")
+	}
+
+	if err := muxer.video.RemoveListener(); err != nil {
+		muxer.logger.Warn().Err(err).Msg("unable to remove video listener")
+	}
+
+	close(muxer.samples)
+	muxer.logger.Info().Msgf("stopped")
+}
+
+func (muxer *AVMuxerCtx) mux(ctx context.Context, audioSamples <-chan types.Sample, videoSamples <-chan types.Sample) {
+	var audio, video avQueue
+
+	ticker := time.NewTicker(muxer.window / 2)
+	defer ticker.Stop()
+
+	// queued samples are not emitted when stopped
+	defer func() {
+		for _, queue := range []*avQueue{&audio, &video} {
+			for _, sample := range queue.samples {
+				sample.Release()
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sample, ok := <-audioSamples:
+			if !ok {
+				audioSamples = nil
+				continue
+			}
+			audio.push(sample, false)
+		case sample, ok := <-videoSamples:
+			if !ok {
+				videoSamples = nil
+				continue
+			}
+			video.push(sample, true)
+		case <-ticker.C:
+		}
+
+		muxer.flush(&audio, &video, time.Now())
+	}
+}
+
+// emits queued samples in presentation order, sample is emitted once the other
+// stream has a later one, its queue is full or it waited longer than window
+func (muxer *AVMuxerCtx) flush(audio, video *avQueue, now time.Time) {
+	for {
+		var queue *avQueue
+
+		switch {
+		case len(audio.samples) > 0 && len(video.samples) > 0:
+			queue = audio
+			if video.samples[0].Time.Before(audio.samples[0].Time) {
+				queue = video
+			}
+		case len(audio.samples) >= avMuxMaxQueue || len(audio.samples) > 0 && now.Sub(audio.samples[0].Timestamp) > muxer.window:
+			queue = audio
+		case len(video.samples) >= avMuxMaxQueue || len(video.samples) > 0 && now.Sub(video.samples[0].Timestamp) > muxer.window:
+			queue = video
+		default:
+			return
+		}
+
+		muxer.emit(queue.pop())
+	}
+}
+
+// never blocks, slow consumer must not stall subscriptions to stream sinks
+func (muxer *AVMuxerCtx) emit(sample AVSample) {
+	select {
+	case muxer.samples <- sample:
+	default:
+		sample.Release()
+		if muxer.droppedSamples.Add(1)%100 == 1 {
+			muxer.logger.Warn().
+				Uint64("dropped", muxer.droppedSamples.Load()).
+				Msg("combined sample buffer is full, dropping samples")
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package capture
+
+import (
+	"sync"
+	"time"
+)
+
+// realized bitrate is computed from samples emitted within this window
+const bitrateStatsWindow = 2 * time.Second
+
+// upper bound of samples in the window, older ones are forgotten if exceeded
+const bitrateStatsSamples = 1024
+
+type bitrateSample struct {
+	size int
+	at   time.Time
+}
+
+// ring buffer of recently emitted samples of the default layer
+type bitrateStats struct {
+	mu      sync.Mutex
+	samples [bitrateStatsSamples]bitrateSample
+	next    int
+	count   int
+}
+
+func (stats *bitrateStats) add(size int, at time.Time) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.samples[stats.next] = bitrateSample{size: size, at: at}
+	stats.next = (stats.next + 1) % bitrateStatsSamples
+	if stats.count < bitrateStatsSamples {
+		stats.count++
+	}
+}
+
+func (stats *bitrateStats) reset() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.next = 0
+	stats.count = 0
+}
+
+// returns bitrate in kbit/s of samples emitted within window before now
+func (stats *bitrateStats) get(now time.Time) int {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	var bytes int
+	var oldest, newest time.Time
+
+	// newest sample first, until window is exceeded
+	for i := 0; i < stats.count; i++ {
+		sample := stats.samples[(stats.next-1-i+bitrateStatsSamples)%bitrateStatsSamples]
+		if now.Sub(sample.at) > bitrateStatsWindow {
+			break
+		}
+
+		if i == 0 {
+			newest = sample.at
+		} else {
+			// bytes of the oldest sample were sent before the measured span
+			bytes += stats.samples[(stats.next-i+bitrateStatsSamples)%bitrateStatsSamples].size
+		}
+		oldest = sample.at
+	}
+
+	span := newest.Sub(oldest)
+	if span <= 0 {
+		return 0
+	}
+
+	return int(int64(bytes) * 8 * int64(time.Second) / int64(span) / 1000)
+}
+
+// returns bitrate in kbit/s actually produced by the encoder, computed from
+// samples of the default layer emitted recently. zero if there were not enough
+// samples, e.g. when pipeline is not running.
+func (manager *StreamSinkManagerCtx) RealizedBitrate() int {
+	return manager.bitrateStats.get(time.Now())
+}
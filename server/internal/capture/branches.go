@@ -0,0 +1,60 @@
+package capture
+
+import (
+	"m1k1o/neko/internal/types"
+)
+
+// returns branches the running pipeline feeds, one per quality layer for webrtc
+// and one for each of broadcast, recording and hls. all of them share state of
+// the pipeline, nil is returned when there is no pipeline.
+func (manager *StreamSinkManagerCtx) Branches() []types.BranchInfo {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return nil
+	}
+
+	state := manager.pipeline.State()
+
+	branches := make([]types.BranchInfo, 0, len(manager.layers)+3)
+	for _, layer := range manager.layers {
+		branches = append(branches, types.BranchInfo{
+			Role:  types.BranchRoleWebRTC,
+			Sink:  manager.appsinkName(layer),
+			Layer: layer,
+			State: state,
+			Bytes: int64(manager.layerBytes[layer].Load()),
+		})
+	}
+
+	if manager.broadcastUrl != "" {
+		branches = append(branches, manager.sinkBranch(types.BranchRoleBroadcast, broadcastName+"sink", manager.broadcastUrl, state))
+	}
+
+	if manager.recordingPath != "" {
+		branches = append(branches, manager.sinkBranch(types.BranchRoleRecord, recordingName+"sink", manager.recordingPath, state))
+	}
+
+	if manager.hlsDir != "" {
+		branches = append(branches, manager.sinkBranch(types.BranchRoleHLS, hlsName+"sink", manager.hlsDir, state))
+	}
+
+	return branches
+}
+
+// bytes are queried from the sink, not all sinks can tell, must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) sinkBranch(role types.BranchRole, sink string, target string, state string) types.BranchInfo {
+	bytes, ok := manager.pipeline.QueryBytes(sink)
+	if !ok {
+		bytes = -1
+	}
+
+	return types.BranchInfo{
+		Role:   role,
+		Sink:   sink,
+		Target: target,
+		State:  state,
+		Bytes:  bytes,
+	}
+}
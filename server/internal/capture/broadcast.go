@@ -97,13 +97,17 @@ func (manager *BroacastManagerCtx) createPipeline() error {
 		Str("src", pipelineStr).
 		Msgf("starting pipeline")
 
-	manager.pipeline, err = gst.CreatePipeline(pipelineStr)
+	pipeline, err := gst.CreatePipeline(pipelineStr)
 	if err != nil {
 		return err
 	}
 
-	manager.pipeline.Play()
+	if err := pipeline.Play(); err != nil {
+		pipeline.Destroy()
+		return err
+	}
 
+	manager.pipeline = pipeline
 	return nil
 }
 
@@ -115,6 +119,13 @@ func (manager *BroacastManagerCtx) destroyPipeline() {
 		return
 	}
 
+	// let flvmux finalize the stream, failed pipeline would only time out
+	if manager.pipeline.State() == "PLAYING" {
+		if err := manager.pipeline.EndOfStream(broadcastFinalizeTimeout); err != nil {
+			manager.logger.Warn().Err(err).Msg("unable to finalize broadcast")
+		}
+	}
+
 	manager.pipeline.Destroy()
 	manager.logger.Info().Msgf("destroying pipeline")
 	manager.pipeline = nil
@@ -0,0 +1,666 @@
+package capture
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"m1k1o/neko/internal/types"
+)
+
+// name of the encoder element in stream pipelines, used to change its properties at runtime
+const encoderName = "encoder"
+
+type encoderBitrate struct {
+	prop  string
+	scale int // multiplier applied to kbit/s, matches the pipeline builders
+}
+
+var encoderBitrates = map[string]encoderBitrate{
+	// video
+	"vp8enc":       {prop: "target-bitrate", scale: 650},
+	"vp9enc":       {prop: "target-bitrate", scale: 1000},
+	"av1enc":       {prop: "target-bitrate", scale: 650},
+	"openh264enc":  {prop: "bitrate", scale: 1000},
+	"x264enc":      {prop: "bitrate", scale: 1},
+	"vaapivp8enc":  {prop: "bitrate", scale: 1},
+	"vaapih264enc": {prop: "bitrate", scale: 1},
+	"nvh264enc":    {prop: "bitrate", scale: 1},
+	// audio
+	"opusenc":    {prop: "bitrate", scale: 1000},
+	"avenc_g722": {prop: "bitrate", scale: 1000},
+	"avenc_aac":  {prop: "bitrate", scale: 1000},
+	"faac":       {prop: "bitrate", scale: 1000},
+	"voaacenc":   {prop: "bitrate", scale: 1000},
+}
+
+// encoder elements producing given codec, used to validate pipelines
+var codecEncoders = map[string][]string{
+	// video
+	"vp8": {"vp8enc", "vaapivp8enc"},
+	"vp9": {"vp9enc", "vaapivp9enc"},
+	// vp9 with temporal layers
+	"vp9-svc": {"vp9enc"},
+	"av1":     {"av1enc", "rav1enc", "svtav1enc"},
+	"h264":    {"x264enc", "openh264enc", "vaapih264enc", "nvh264enc", "qsvh264enc", "v4l2h264enc"},
+	// audio
+	"opus": {"opusenc"},
+	"g722": {"avenc_g722"},
+	"pcmu": {"mulawenc"},
+	"pcma": {"alawenc"},
+	"aac":  {"avenc_aac", "faac", "voaacenc"},
+}
+
+// gstreamer fails with cryptic error on empty pipeline, so it is rejected early
+func validatePipelineNotEmpty(pipelineStr string) error {
+	if strings.TrimSpace(pipelineStr) == "" {
+		return fmt.Errorf("%w: pipeline string is empty", types.ErrPipelineParse)
+	}
+
+	for _, el := range strings.Split(pipelineStr, "!") {
+		if strings.TrimSpace(el) == "" {
+			return fmt.Errorf("%w: pipeline string contains empty element", types.ErrPipelineParse)
+		}
+	}
+
+	return nil
+}
+
+// checks that encoder in pipeline string produces given codec, pipelines with
+// unknown encoders are accepted because they cannot be verified
+func validatePipelineCodec(pipelineStr string, codecName string) (bool, error) {
+	elements := map[string]bool{}
+	for _, el := range strings.Split(pipelineStr, "!") {
+		if fields := strings.Fields(el); len(fields) > 0 {
+			elements[fields[0]] = true
+		}
+	}
+
+	for _, encoder := range codecEncoders[codecName] {
+		if elements[encoder] {
+			return true, nil
+		}
+	}
+
+	for name, encoders := range codecEncoders {
+		for _, encoder := range encoders {
+			if elements[encoder] {
+				return false, fmt.Errorf("%w: pipeline contains %s encoder %s, but codec %s is configured", types.ErrPipelineParse, name, encoder, codecName)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// find first known encoder in pipeline string, returns its index in elements
+func findEncoder(elements []string) (int, string, bool) {
+	for i, el := range elements {
+		fields := strings.Fields(el)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if _, ok := encoderBitrates[fields[0]]; ok {
+			return i, fields[0], true
+		}
+	}
+
+	return -1, "", false
+}
+
+// property of element in pipeline string, its name is in group 2 and value in group 3
+var elementPropRe = regexp.MustCompile(`(^|\s)([\w-]+)=(\S+)`)
+
+// get value of property of element in pipeline string
+func elementProp(el string, name string) (string, bool) {
+	for _, match := range elementPropRe.FindAllStringSubmatch(el, -1) {
+		if match[2] == name {
+			return match[3], true
+		}
+	}
+
+	return "", false
+}
+
+// rewrite property of element in pipeline string, adds it after factory if missing
+func setElementProp(el string, factory string, name string, value string) string {
+	found := false
+	el = elementPropRe.ReplaceAllStringFunc(el, func(prop string) string {
+		match := elementPropRe.FindStringSubmatch(prop)
+		if match[2] != name {
+			return prop
+		}
+
+		found = true
+		return match[1] + name + "=" + value
+	})
+
+	if !found {
+		el = strings.Replace(el, factory, factory+" "+name+"="+value, 1)
+	}
+
+	return el
+}
+
+// rewrite bitrate property of the encoder in pipeline string, adds it if missing
+func setPipelineBitrate(pipelineStr string, kbps int) (string, error) {
+	elements := strings.Split(pipelineStr, "!")
+
+	i, factory, ok := findEncoder(elements)
+	if !ok {
+		return "", fmt.Errorf("%w: no known encoder found in pipeline", types.ErrPipelineParse)
+	}
+
+	enc := encoderBitrates[factory]
+	elements[i] = setElementProp(elements[i], factory, enc.prop, strconv.Itoa(kbps*enc.scale))
+
+	return strings.Join(elements, "!"), nil
+}
+
+// get bitrate property of the encoder in pipeline string and its value for given kbit/s
+func pipelineBitrateProp(pipelineStr string, kbps int) (string, int, bool) {
+	_, factory, ok := findEncoder(strings.Split(pipelineStr, "!"))
+	if !ok {
+		return "", 0, false
+	}
+
+	enc := encoderBitrates[factory]
+	return enc.prop, kbps * enc.scale, true
+}
+
+// get bitrate of the encoder in pipeline string in kbit/s, zero if not found
+func pipelineBitrate(pipelineStr string) int {
+	elements := strings.Split(pipelineStr, "!")
+
+	i, factory, ok := findEncoder(elements)
+	if !ok {
+		return 0
+	}
+
+	enc := encoderBitrates[factory]
+	prop, ok := elementProp(elements[i], enc.prop)
+	if !ok {
+		return 0
+	}
+
+	value, _ := strconv.Atoi(prop)
+	return value / enc.scale
+}
+
+type encoderRateControl struct {
+	prop  string
+	modes map[types.RateControlMode]string // mode to property value
+	live  bool                             // encoder applies change while playing
+}
+
+var encoderRateControls = map[string]encoderRateControl{
+	"vp8enc":       {prop: "end-usage", modes: map[types.RateControlMode]string{types.RateControlCBR: "cbr", types.RateControlVBR: "vbr"}, live: true},
+	"vp9enc":       {prop: "end-usage", modes: map[types.RateControlMode]string{types.RateControlCBR: "cbr", types.RateControlVBR: "vbr"}, live: true},
+	"av1enc":       {prop: "end-usage", modes: map[types.RateControlMode]string{types.RateControlCBR: "cbr", types.RateControlVBR: "vbr"}},
+	"x264enc":      {prop: "pass", modes: map[types.RateControlMode]string{types.RateControlCBR: "cbr", types.RateControlVBR: "qual"}},
+	"openh264enc":  {prop: "rate-control", modes: map[types.RateControlMode]string{types.RateControlCBR: "bitrate", types.RateControlVBR: "quality"}},
+	"vaapivp8enc":  {prop: "rate-control", modes: map[types.RateControlMode]string{types.RateControlCBR: "cbr", types.RateControlVBR: "vbr"}},
+	"vaapih264enc": {prop: "rate-control", modes: map[types.RateControlMode]string{types.RateControlCBR: "cbr", types.RateControlVBR: "vbr"}},
+	"nvh264enc":    {prop: "rc-mode", modes: map[types.RateControlMode]string{types.RateControlCBR: "cbr", types.RateControlVBR: "vbr"}},
+}
+
+// returns rate control of the encoder in pipeline string and property value for
+// given mode, error lists supported modes if the mode is not supported
+func pipelineRateControlProp(pipelineStr string, mode types.RateControlMode) (string, string, bool, error) {
+	_, factory, ok := findEncoder(strings.Split(pipelineStr, "!"))
+	if !ok {
+		return "", "", false, fmt.Errorf("%w: no known encoder found in pipeline", types.ErrPipelineParse)
+	}
+
+	return encoderRateControlProp(factory, mode)
+}
+
+// returns rate control property of given encoder and its value for given mode
+func encoderRateControlProp(factory string, mode types.RateControlMode) (string, string, bool, error) {
+	rc, ok := encoderRateControls[factory]
+	if !ok {
+		return "", "", false, fmt.Errorf("encoder %s does not support rate control modes", factory)
+	}
+
+	value, ok := rc.modes[mode]
+	if !ok {
+		supported := make([]string, 0, len(rc.modes))
+		for name := range rc.modes {
+			supported = append(supported, string(name))
+		}
+		sort.Strings(supported)
+
+		return "", "", false, fmt.Errorf("encoder %s does not support rate control mode %s, supported modes are %s", factory, mode, strings.Join(supported, ", "))
+	}
+
+	return rc.prop, value, rc.live, nil
+}
+
+// rewrite rate control property of the encoder in pipeline string, adds it if missing
+func setPipelineRateControl(pipelineStr string, mode types.RateControlMode) (string, error) {
+	prop, value, _, err := pipelineRateControlProp(pipelineStr, mode)
+	if err != nil {
+		return "", err
+	}
+
+	elements := strings.Split(pipelineStr, "!")
+	i, factory, _ := findEncoder(elements)
+
+	elements[i] = setElementProp(elements[i], factory, prop, value)
+
+	return strings.Join(elements, "!"), nil
+}
+
+type encoderPreset struct {
+	prop    string
+	presets []string // from fastest to best quality
+}
+
+// encoders supporting speed and quality tradeoff, none of them applies it while playing
+var encoderPresets = map[string]encoderPreset{
+	"x264enc":      {prop: "speed-preset", presets: []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}},
+	"openh264enc":  {prop: "complexity", presets: []string{"low", "medium", "high"}},
+	"vaapih264enc": {prop: "quality-level", presets: []string{"7", "6", "5", "4", "3", "2", "1"}},
+	"vaapivp8enc":  {prop: "quality-level", presets: []string{"7", "6", "5", "4", "3", "2", "1"}},
+	"nvh264enc":    {prop: "preset", presets: []string{"low-latency-hp", "hp", "low-latency", "default", "low-latency-hq", "hq"}},
+}
+
+// returns preset property of the encoder in pipeline string, error lists valid
+// presets if the preset is not valid for the encoder
+func pipelineEncoderPresetProp(pipelineStr string, preset string) (string, error) {
+	_, factory, ok := findEncoder(strings.Split(pipelineStr, "!"))
+	if !ok {
+		return "", fmt.Errorf("%w: no known encoder found in pipeline", types.ErrPipelineParse)
+	}
+
+	return encoderPresetProp(factory, preset)
+}
+
+// returns preset property of given encoder if the preset is valid for it
+func encoderPresetProp(factory string, preset string) (string, error) {
+	ep, ok := encoderPresets[factory]
+	if !ok {
+		return "", fmt.Errorf("encoder %s does not support presets", factory)
+	}
+
+	for _, p := range ep.presets {
+		if p == preset {
+			return ep.prop, nil
+		}
+	}
+
+	return "", fmt.Errorf("encoder %s does not support preset %s, valid presets are %s", factory, preset, strings.Join(ep.presets, ", "))
+}
+
+// rewrite preset property of the encoder in pipeline string, adds it if missing
+func setPipelineEncoderPreset(pipelineStr string, preset string) (string, error) {
+	prop, err := pipelineEncoderPresetProp(pipelineStr, preset)
+	if err != nil {
+		return "", err
+	}
+
+	elements := strings.Split(pipelineStr, "!")
+	i, factory, _ := findEncoder(elements)
+
+	elements[i] = setElementProp(elements[i], factory, prop, preset)
+
+	return strings.Join(elements, "!"), nil
+}
+
+// encoders able to refresh picture gradually instead of with periodic keyframes,
+// keyframe interval is then used as refresh period. vpx, openh264, vaapi and
+// nvenc encoders do not support it.
+var encoderIntraRefresh = map[string]string{
+	"x264enc": "intra-refresh",
+}
+
+// returns intra refresh property of the encoder in pipeline string
+func pipelineIntraRefreshProp(pipelineStr string) (string, error) {
+	_, factory, ok := findEncoder(strings.Split(pipelineStr, "!"))
+	if !ok {
+		return "", fmt.Errorf("%w: no known encoder found in pipeline", types.ErrPipelineParse)
+	}
+
+	return encoderIntraRefreshProp(factory)
+}
+
+func encoderIntraRefreshProp(factory string) (string, error) {
+	prop, ok := encoderIntraRefresh[factory]
+	if !ok {
+		return "", fmt.Errorf("encoder %s does not support intra refresh", factory)
+	}
+
+	return prop, nil
+}
+
+// enables intra refresh of the encoder in pipeline string
+func setPipelineIntraRefresh(pipelineStr string) (string, error) {
+	prop, err := pipelineIntraRefreshProp(pipelineStr)
+	if err != nil {
+		return "", err
+	}
+
+	elements := strings.Split(pipelineStr, "!")
+	i, factory, _ := findEncoder(elements)
+
+	elements[i] = setElementProp(elements[i], factory, prop, "true")
+
+	return strings.Join(elements, "!"), nil
+}
+
+// bounds of keyframe interval in frames, longer intervals make new viewers and
+// decoders recovering from packet loss wait too long
+const (
+	minKeyframeInterval = 1
+	maxKeyframeInterval = 600
+)
+
+type encoderKeyframeInterval struct {
+	prop string
+	live bool // applied while playing
+}
+
+var encoderKeyframeIntervals = map[string]encoderKeyframeInterval{
+	"vp8enc":       {prop: "keyframe-max-dist", live: true},
+	"vp9enc":       {prop: "keyframe-max-dist", live: true},
+	"av1enc":       {prop: "keyframe-max-dist"},
+	"openh264enc":  {prop: "gop-size"},
+	"x264enc":      {prop: "key-int-max"},
+	"vaapivp8enc":  {prop: "keyframe-period"},
+	"vaapih264enc": {prop: "keyframe-period"},
+	"nvh264enc":    {prop: "gop-size"},
+}
+
+// returns keyframe interval property of the encoder in pipeline string and
+// whether it is applied while playing
+func pipelineKeyframeIntervalProp(pipelineStr string) (string, bool, error) {
+	_, factory, ok := findEncoder(strings.Split(pipelineStr, "!"))
+	if !ok {
+		return "", false, fmt.Errorf("%w: no known encoder found in pipeline", types.ErrPipelineParse)
+	}
+
+	return encoderKeyframeIntervalProp(factory)
+}
+
+func encoderKeyframeIntervalProp(factory string) (string, bool, error) {
+	ki, ok := encoderKeyframeIntervals[factory]
+	if !ok {
+		return "", false, fmt.Errorf("encoder %s does not support keyframe interval", factory)
+	}
+
+	return ki.prop, ki.live, nil
+}
+
+// rewrite keyframe interval property of the encoder in pipeline string, adds it if missing
+func setPipelineKeyframeInterval(pipelineStr string, frames int) (string, error) {
+	prop, _, err := pipelineKeyframeIntervalProp(pipelineStr)
+	if err != nil {
+		return "", err
+	}
+
+	elements := strings.Split(pipelineStr, "!")
+	i, factory, _ := findEncoder(elements)
+
+	elements[i] = setElementProp(elements[i], factory, prop, strconv.Itoa(frames))
+
+	return strings.Join(elements, "!"), nil
+}
+
+type encoderQP struct {
+	minProp string
+	maxProp string
+	max     int  // quantizer range starts at zero
+	live    bool // applied while playing
+}
+
+var encoderQPs = map[string]encoderQP{
+	"vp8enc":       {minProp: "min-quantizer", maxProp: "max-quantizer", max: 63, live: true},
+	"vp9enc":       {minProp: "min-quantizer", maxProp: "max-quantizer", max: 63, live: true},
+	"av1enc":       {minProp: "min-quantizer", maxProp: "max-quantizer", max: 63},
+	"openh264enc":  {minProp: "qp-min", maxProp: "qp-max", max: 51},
+	"x264enc":      {minProp: "qp-min", maxProp: "qp-max", max: 51},
+	"vaapih264enc": {minProp: "min-qp", maxProp: "max-qp", max: 51},
+	"nvh264enc":    {minProp: "qp-min", maxProp: "qp-max", max: 51},
+}
+
+// returns quantizer properties of the encoder in pipeline string, error tells
+// valid range if min and max are not within it
+func pipelineQPProps(pipelineStr string, min, max int) (encoderQP, error) {
+	_, factory, ok := findEncoder(strings.Split(pipelineStr, "!"))
+	if !ok {
+		return encoderQP{}, fmt.Errorf("%w: no known encoder found in pipeline", types.ErrPipelineParse)
+	}
+
+	return encoderQPProps(factory, min, max)
+}
+
+func encoderQPProps(factory string, min, max int) (encoderQP, error) {
+	qp, ok := encoderQPs[factory]
+	if !ok {
+		return encoderQP{}, fmt.Errorf("encoder %s does not support quantizer control", factory)
+	}
+
+	if min < 0 || max > qp.max || min > max {
+		return encoderQP{}, fmt.Errorf("invalid quantizer range %d-%d, encoder %s supports 0-%d", min, max, factory, qp.max)
+	}
+
+	return qp, nil
+}
+
+// rewrite quantizer properties of the encoder in pipeline string, adds them if missing
+func setPipelineQP(pipelineStr string, min, max int) (string, error) {
+	qp, err := pipelineQPProps(pipelineStr, min, max)
+	if err != nil {
+		return "", err
+	}
+
+	elements := strings.Split(pipelineStr, "!")
+	i, factory, _ := findEncoder(elements)
+
+	props := []string{qp.minProp, qp.maxProp}
+	for j, value := range []int{min, max} {
+		elements[i] = setElementProp(elements[i], factory, props[j], strconv.Itoa(value))
+	}
+
+	return strings.Join(elements, "!"), nil
+}
+
+// name of capsfilter setting capture framerate, so that it can be changed at runtime
+const framerateCapsName = "framerate"
+
+// matches framerate=30/1, framerate=30000/1001 and framerate=(fraction)30/1 in caps
+var framerateRegex = regexp.MustCompile(`framerate\s*=\s*(\(fraction\)\s*)?(\d+)/(\d+)`)
+
+// get framerate from caps in pipeline string rounded to whole number, zero if not found
+func pipelineFramerate(pipelineStr string) int16 {
+	match := framerateRegex.FindStringSubmatch(pipelineStr)
+	if match == nil {
+		return 0
+	}
+
+	numerator, _ := strconv.Atoi(match[2])
+	denominator, _ := strconv.Atoi(match[3])
+	if denominator == 0 {
+		return 0
+	}
+
+	return int16(math.Round(float64(numerator) / float64(denominator)))
+}
+
+// rewrite all framerates in caps of pipeline string, returns false if none was found
+func setPipelineFramerate(pipelineStr string, framerate int16) (string, bool) {
+	if !framerateRegex.MatchString(pipelineStr) {
+		return pipelineStr, false
+	}
+
+	return framerateRegex.ReplaceAllString(pipelineStr, fmt.Sprintf("framerate=${1}%d/1", framerate)), true
+}
+
+// name of capsfilter used to scale video, so that resolution can be changed at runtime
+const resolutionCapsName = "resolution"
+
+// dimensions in caps of scaling capsfilter, optional type is kept in group 1
+var capsWidthRe = regexp.MustCompile(`width=(\(int\))?\d+`)
+var capsHeightRe = regexp.MustCompile(`height=(\(int\))?\d+`)
+
+// codecs using chroma subsampling that requires even frame dimensions
+var codecRequiresEvenResolution = map[string]bool{
+	"vp8":     true,
+	"vp9":     true,
+	"vp9-svc": true,
+	"av1":     true,
+	"h264":    true,
+}
+
+// rewrite resolution of scaling capsfilter in pipeline string, it is inserted
+// before the encoder if missing
+func setPipelineResolution(pipelineStr string, width, height int) (string, error) {
+	elements := strings.Split(pipelineStr, "!")
+
+	for i, el := range elements {
+		if !strings.Contains(el, "name="+resolutionCapsName) {
+			continue
+		}
+
+		el = capsWidthRe.ReplaceAllString(el, fmt.Sprintf("width=${1}%d", width))
+		el = capsHeightRe.ReplaceAllString(el, fmt.Sprintf("height=${1}%d", height))
+		elements[i] = el
+		return strings.Join(elements, "!"), nil
+	}
+
+	i, _, ok := findEncoder(elements)
+	if !ok {
+		return "", fmt.Errorf("%w: no known encoder found in pipeline", types.ErrPipelineParse)
+	}
+
+	scale := []string{
+		" videoscale ",
+		fmt.Sprintf(" capsfilter name=%s caps=video/x-raw,width=%d,height=%d ", resolutionCapsName, width, height),
+	}
+
+	elements = append(elements[:i], append(scale, elements[i:]...)...)
+	return strings.Join(elements, "!"), nil
+}
+
+// temporal layer ids of frames in one period of vp9-svc pipeline, frames are
+// assigned to layers in this order starting with the first frame of pipeline
+var vp9SVCTemporalLayers = []uint8{0, 1}
+
+// temporal layer of n-th frame since pipeline was created, encoder does not
+// report it, so it is derived from the same pattern the encoder is set up with
+func temporalLayer(codecName string, frame uint64) uint8 {
+	if codecName != "vp9-svc" {
+		return 0
+	}
+
+	return vp9SVCTemporalLayers[frame%uint64(len(vp9SVCTemporalLayers))]
+}
+
+// name of the capture source element, so that its properties can be changed at runtime
+const sourceName = "source"
+
+// rewrite properties of ximagesrc in pipeline string, adds them if missing,
+// feature is used in error when source is not ximagesrc
+func setPipelineSourceProps(pipelineStr string, feature string, props map[string]string) (string, error) {
+	elements := strings.Split(pipelineStr, "!")
+
+	fields := strings.Fields(elements[0])
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: pipeline is empty", types.ErrPipelineParse)
+	}
+
+	if fields[0] != "ximagesrc" {
+		return "", fmt.Errorf("source element %s does not support %s", fields[0], feature)
+	}
+
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		elements[0] = setElementProp(elements[0], "ximagesrc", name, props[name])
+	}
+
+	return strings.Join(elements, "!"), nil
+}
+
+// rewrite show-pointer property of ximagesrc in pipeline string, adds it if missing
+func setPipelineCursor(pipelineStr string, visible bool) (string, error) {
+	return setPipelineSourceProps(pipelineStr, "cursor control", map[string]string{
+		"show-pointer": strconv.FormatBool(visible),
+	})
+}
+
+// rewrite captured region of ximagesrc in pipeline string, end coordinates are inclusive
+func setPipelineRegion(pipelineStr string, x, y, width, height int) (string, error) {
+	return setPipelineSourceProps(pipelineStr, "region capture", map[string]string{
+		"startx": strconv.Itoa(x),
+		"starty": strconv.Itoa(y),
+		"endx":   strconv.Itoa(x + width - 1),
+		"endy":   strconv.Itoa(y + height - 1),
+	})
+}
+
+// pipeline string that tunables are applied to in place
+type pipelineString struct {
+	src string
+}
+
+func (p *pipelineString) set(src string, err error) error {
+	if err != nil {
+		return err
+	}
+
+	p.src = src
+	return nil
+}
+
+func (p *pipelineString) SetBitrate(kbps int) error {
+	return p.set(setPipelineBitrate(p.src, kbps))
+}
+
+func (p *pipelineString) SetRateControl(mode types.RateControlMode) error {
+	return p.set(setPipelineRateControl(p.src, mode))
+}
+
+func (p *pipelineString) SetEncoderPreset(preset string) error {
+	return p.set(setPipelineEncoderPreset(p.src, preset))
+}
+
+func (p *pipelineString) SetIntraRefresh() error {
+	return p.set(setPipelineIntraRefresh(p.src))
+}
+
+func (p *pipelineString) SetKeyframeInterval(frames int) error {
+	return p.set(setPipelineKeyframeInterval(p.src, frames))
+}
+
+func (p *pipelineString) SetQP(min, max int) error {
+	return p.set(setPipelineQP(p.src, min, max))
+}
+
+func (p *pipelineString) SetTransform(method string) error {
+	return p.set(setPipelineTransform(p.src, method))
+}
+
+func (p *pipelineString) SetResolution(width, height int) error {
+	return p.set(setPipelineResolution(p.src, width, height))
+}
+
+func (p *pipelineString) SetFramerate(framerate int16) bool {
+	src, ok := setPipelineFramerate(p.src, framerate)
+	p.src = src
+	return ok
+}
+
+func (p *pipelineString) SetQueues(leaky string, maxBuffers int) bool {
+	src, ok := setPipelineQueues(p.src, leaky, maxBuffers)
+	p.src = src
+	return ok
+}
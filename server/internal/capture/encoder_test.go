@@ -0,0 +1,52 @@
+package capture
+
+import (
+	"errors"
+	"testing"
+
+	"m1k1o/neko/internal/types"
+)
+
+func TestValidatePipelineNotEmpty(t *testing.T) {
+	tests := []struct {
+		name        string
+		pipelineStr string
+		err         error
+	}{
+		{"empty", "", types.ErrPipelineParse},
+		{"whitespace only", " \t\n ", types.ErrPipelineParse},
+		{"empty element", "videotestsrc ! ! fakesink", types.ErrPipelineParse},
+		{"whitespace element", "videotestsrc !   ! fakesink", types.ErrPipelineParse},
+		{"valid", "videotestsrc ! fakesink", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePipelineNotEmpty(tt.pipelineStr); !errors.Is(err, tt.err) {
+				t.Fatalf("validatePipelineNotEmpty returned %v, expected %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSetElementProp(t *testing.T) {
+	tests := []struct {
+		name     string
+		el       string
+		prop     string
+		expected string
+	}{
+		{"replaced", " x264enc bitrate=500 name=encoder ", "bitrate", " x264enc bitrate=900 name=encoder "},
+		{"added", " x264enc name=encoder ", "bitrate", " x264enc bitrate=900 name=encoder "},
+		{"prefixed name kept", " x264enc max-bitrate=10 ", "bitrate", " x264enc bitrate=900 max-bitrate=10 "},
+		{"caps field kept", " x264enc caps=video/x-raw,bitrate=10 ", "bitrate", " x264enc bitrate=900 caps=video/x-raw,bitrate=10 "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if el := setElementProp(tt.el, "x264enc", tt.prop, "900"); el != tt.expected {
+				t.Fatalf("setElementProp returned %q, expected %q", el, tt.expected)
+			}
+		})
+	}
+}
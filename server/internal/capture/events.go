@@ -0,0 +1,47 @@
+package capture
+
+import (
+	"time"
+
+	"m1k1o/neko/internal/types"
+)
+
+// number of events kept for slow receiver, oldest are dropped first
+const eventsBufferSize = 16
+
+// sends pipeline lifecycle event without blocking, when receiver is too slow
+// the oldest event is dropped so that the latest state is never lost
+func (manager *StreamSinkManagerCtx) emitEvent(eventType types.PipelineEventType, err error) {
+	event := types.PipelineEvent{
+		Type:  eventType,
+		Time:  time.Now(),
+		Codec: manager.Codec().Name,
+		Error: err,
+	}
+
+	manager.eventsMu.Lock()
+	defer manager.eventsMu.Unlock()
+
+	for {
+		select {
+		case manager.events <- event:
+			return
+		default:
+		}
+
+		select {
+		case dropped := <-manager.events:
+			manager.logger().Debug().
+				Str("event", string(dropped.Type)).
+				Msg("events receiver is too slow, dropping oldest event")
+		default:
+		}
+	}
+}
+
+// returns channel of pipeline lifecycle events. the channel is shared by all
+// callers, so every event is received by one of them only. it is never closed
+// and it is never blocked on, oldest events are dropped if it is not read.
+func (manager *StreamSinkManagerCtx) Events() <-chan types.PipelineEvent {
+	return manager.events
+}
@@ -0,0 +1,369 @@
+package capture
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"m1k1o/neko/internal/types"
+	"m1k1o/neko/internal/types/codec"
+)
+
+// pipeline used instead of gstreamer one, test feeds its appsinks with push,
+// simulates bus errors with fail and inspects recorded calls
+type fakePipeline struct {
+	factory *fakePipelines
+
+	mu        sync.Mutex
+	src       string
+	state     string
+	appsinks  map[string]chan types.Sample
+	errs      chan error
+	playing   chan struct{}
+	destroyed chan struct{}
+	isPlaying bool
+	dead      bool
+
+	playErr    error
+	refuseLive bool // property and caps changes fail, so that pipeline is recreated
+
+	plays    int
+	pauses   int
+	destroys int
+}
+
+var _ types.Pipeline = &fakePipeline{}
+
+func (p *fakePipeline) Src() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.src
+}
+
+func (p *fakePipeline) SetSrc(src string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.src = src
+}
+
+func (p *fakePipeline) State() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.state
+}
+
+func (p *fakePipeline) Errors() <-chan error {
+	return p.errs
+}
+
+func (p *fakePipeline) Playing() <-chan struct{} {
+	return p.playing
+}
+
+func (p *fakePipeline) Destroyed() <-chan struct{} {
+	return p.destroyed
+}
+
+func (p *fakePipeline) AttachAppsink(sinkName string, sampleChannel chan types.Sample) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.appsinks[sinkName] = sampleChannel
+	return nil
+}
+
+func (p *fakePipeline) SetLatestOnly(enabled bool)               {}
+func (p *fakePipeline) SetLeaseBuffers(enabled bool)             {}
+func (p *fakePipeline) SetDroppedCounter(counter *atomic.Uint64) {}
+
+func (p *fakePipeline) Play() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.plays++
+	if p.playErr != nil {
+		return p.playErr
+	}
+
+	p.state = "PLAYING"
+	if !p.isPlaying {
+		p.isPlaying = true
+		close(p.playing)
+	}
+
+	return nil
+}
+
+func (p *fakePipeline) Pause() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pauses++
+	p.state = "PAUSED"
+	return nil
+}
+
+func (p *fakePipeline) Destroy() {
+	p.mu.Lock()
+	p.destroys++
+	if p.dead {
+		p.mu.Unlock()
+		return
+	}
+
+	p.dead = true
+	p.state = "NULL"
+	close(p.errs)
+	close(p.destroyed)
+	p.mu.Unlock()
+
+	p.factory.destroyed(p)
+}
+
+func (p *fakePipeline) SetPropInt(binName string, prop string, value int) bool {
+	return !p.refuseLive
+}
+
+func (p *fakePipeline) SetPropString(binName string, prop string, value string) bool {
+	return !p.refuseLive
+}
+
+func (p *fakePipeline) GetPropString(binName string, prop string) (string, bool) {
+	return "", false
+}
+
+func (p *fakePipeline) GetPadCaps(binName string, padName string) (string, bool) {
+	return "", false
+}
+
+func (p *fakePipeline) SetCapsFramerate(binName string, numerator, denominator int) bool {
+	return !p.refuseLive
+}
+
+func (p *fakePipeline) SetCapsResolution(binName string, width, height int) bool {
+	return !p.refuseLive
+}
+
+func (p *fakePipeline) ForceKeyUnit() bool {
+	return true
+}
+
+func (p *fakePipeline) EndBranch(srcName string, sinkName string, timeout time.Duration) error {
+	return nil
+}
+
+func (p *fakePipeline) EndOfStream(timeout time.Duration) error {
+	return nil
+}
+
+func (p *fakePipeline) QueryBytes(binName string) (int64, bool) {
+	return 0, true
+}
+
+func (p *fakePipeline) QueryLatency() (time.Duration, bool) {
+	return 0, true
+}
+
+func (p *fakePipeline) RebaseTimestamps(binName string) bool {
+	return true
+}
+
+func (p *fakePipeline) DumpDot() (string, bool) {
+	return "digraph pipeline {}", true
+}
+
+// passes sample to appsink as streaming thread would, false if appsink is
+// not attached or its channel is full
+func (p *fakePipeline) push(sinkName string, sample types.Sample) bool {
+	p.mu.Lock()
+	samples, ok := p.appsinks[sinkName]
+	p.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	select {
+	case samples <- sample:
+		return true
+	default:
+		return false
+	}
+}
+
+// reports error on the bus, ignored after pipeline was destroyed
+func (p *fakePipeline) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.dead {
+		return
+	}
+
+	select {
+	case p.errs <- err:
+	default:
+	}
+}
+
+func (p *fakePipeline) calls() (plays, pauses, destroys int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.plays, p.pauses, p.destroys
+}
+
+// creates fake pipelines in place of gstreamer and counts how many of them
+// exist at once, so that leaked or duplicated pipelines are found
+type fakePipelines struct {
+	mu       sync.Mutex
+	created  []*fakePipeline
+	alive    int
+	maxAlive int
+
+	// applied to pipelines created next
+	createErr  error
+	playErr    error
+	refuseLive bool
+}
+
+func (f *fakePipelines) create(pipelineStr string) (types.Pipeline, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+
+	p := &fakePipeline{
+		factory:    f,
+		src:        pipelineStr,
+		state:      "READY",
+		appsinks:   map[string]chan types.Sample{},
+		errs:       make(chan error, 1),
+		playing:    make(chan struct{}),
+		destroyed:  make(chan struct{}),
+		playErr:    f.playErr,
+		refuseLive: f.refuseLive,
+	}
+
+	f.created = append(f.created, p)
+	f.alive++
+	if f.alive > f.maxAlive {
+		f.maxAlive = f.alive
+	}
+
+	return p, nil
+}
+
+func (f *fakePipelines) destroyed(p *fakePipeline) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.alive--
+}
+
+// returns the most recently created pipeline, nil if none was created
+func (f *fakePipelines) last() *fakePipeline {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.created) == 0 {
+		return nil
+	}
+
+	return f.created[len(f.created)-1]
+}
+
+func (f *fakePipelines) count() (created, alive, maxAlive int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.created), f.alive, f.maxAlive
+}
+
+// vp8 pipeline with named framerate caps, so that tunables can be applied to it
+func testPipelineFn(params PipelineParams) (string, error) {
+	fps := int(params.Framerate)
+	if fps <= 0 {
+		fps = 25
+	}
+
+	return fmt.Sprintf(videoSrc, ":0", fps) + "vp8enc name=encoder ! appsink name=appsinkvideo", nil
+}
+
+// returns manager creating fake pipelines, it is shut down when test ends
+func newTestManager(t testing.TB, pipelines *fakePipelines) *StreamSinkManagerCtx {
+	t.Helper()
+
+	manager := streamSinkNew(codec.VP8(), testPipelineFn, "test", 0, nil, 0, 0, zerolog.Nop())
+	manager.createPipelineFn = pipelines.create
+	manager.checkPluginsFn = func(plugins []string) error { return nil }
+	t.Cleanup(manager.shutdown)
+
+	return manager
+}
+
+// returns pipeline the manager currently uses, nil if there is none
+func currentPipeline(manager *StreamSinkManagerCtx) *fakePipeline {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return nil
+	}
+
+	return manager.pipeline.(*fakePipeline)
+}
+
+func TestFakePipelineLifecycle(t *testing.T) {
+	pipelines := &fakePipelines{}
+	manager := newTestManager(t, pipelines)
+
+	samples, err := manager.Subscribe("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline := currentPipeline(manager)
+	if pipeline == nil {
+		t.Fatal("pipeline was not created")
+	}
+
+	if plays, _, _ := pipeline.calls(); plays != 1 {
+		t.Fatalf("pipeline was played %d times, expected once", plays)
+	}
+
+	if !pipeline.push("appsinkvideo", types.Sample{PTS: -1, Keyframe: true}) {
+		t.Fatal("unable to push sample")
+	}
+
+	select {
+	case <-samples:
+	case <-time.After(time.Second):
+		t.Fatal("sample was not delivered to subscriber")
+	}
+
+	if err := manager.RemoveListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, destroys := pipeline.calls(); destroys != 1 {
+		t.Fatalf("pipeline was destroyed %d times, expected once", destroys)
+	}
+
+	if _, alive, _ := pipelines.count(); alive != 0 {
+		t.Fatalf("%d pipelines are left alive", alive)
+	}
+}
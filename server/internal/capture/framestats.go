@@ -0,0 +1,122 @@
+package capture
+
+import (
+	"math"
+	"sync"
+
+	"m1k1o/neko/internal/types"
+)
+
+// number of most recent frames the stats are computed from
+const frameStatsWindow = 300
+
+// upper bounds of frame size histogram buckets in bytes, last one is unbounded
+var frameSizeBuckets = []int{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, math.MaxInt}
+
+type frameInfo struct {
+	size     int
+	keyframe bool
+}
+
+// ring buffer of recent frames of the default layer
+type frameStats struct {
+	mu     sync.Mutex
+	frames [frameStatsWindow]frameInfo
+	next   int
+	count  int
+}
+
+func (stats *frameStats) add(size int, keyframe bool) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.frames[stats.next] = frameInfo{size: size, keyframe: keyframe}
+	stats.next = (stats.next + 1) % frameStatsWindow
+	if stats.count < frameStatsWindow {
+		stats.count++
+	}
+}
+
+func (stats *frameStats) reset() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.next = 0
+	stats.count = 0
+}
+
+func (stats *frameStats) get() types.StreamFrameStats {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	result := types.StreamFrameStats{
+		Frames:    stats.count,
+		Histogram: make([]types.FrameSizeBucket, len(frameSizeBuckets)),
+	}
+
+	for i, bound := range frameSizeBuckets {
+		result.Histogram[i].UpperBound = bound
+	}
+
+	var totalSize, keyframeSize, deltaSize int
+	var lastKeyframe, keyframeDistances, keyframeDistancesSum int
+	lastKeyframe = -1
+
+	// oldest frame first, so that keyframe distances are in order
+	start := (stats.next - stats.count + frameStatsWindow) % frameStatsWindow
+	for i := 0; i < stats.count; i++ {
+		frame := stats.frames[(start+i)%frameStatsWindow]
+
+		totalSize += frame.size
+		if frame.size > result.MaxFrameSize {
+			result.MaxFrameSize = frame.size
+		}
+
+		if frame.keyframe {
+			result.Keyframes++
+			keyframeSize += frame.size
+			if frame.size > result.MaxKeyframeSize {
+				result.MaxKeyframeSize = frame.size
+			}
+
+			if lastKeyframe >= 0 {
+				keyframeDistances++
+				keyframeDistancesSum += i - lastKeyframe
+			}
+			lastKeyframe = i
+		} else {
+			deltaSize += frame.size
+		}
+
+		for j, bound := range frameSizeBuckets {
+			if frame.size <= bound {
+				result.Histogram[j].Count++
+				break
+			}
+		}
+	}
+
+	if result.Frames > 0 {
+		result.AvgFrameSize = totalSize / result.Frames
+	}
+
+	if result.Keyframes > 0 {
+		result.AvgKeyframeSize = keyframeSize / result.Keyframes
+	}
+
+	if deltas := result.Frames - result.Keyframes; deltas > 0 {
+		result.AvgDeltaSize = deltaSize / deltas
+	}
+
+	if keyframeDistances > 0 {
+		result.KeyframeInterval = float64(keyframeDistancesSum) / float64(keyframeDistances)
+	}
+
+	return result
+}
+
+// returns size and keyframe stats of recent frames of the default layer, they
+// are reset when pipeline is recreated
+func (manager *StreamSinkManagerCtx) FrameStats() types.StreamFrameStats {
+	return manager.frameStats.get()
+}
@@ -0,0 +1,339 @@
+package capture
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"m1k1o/neko/internal/types"
+)
+
+// element of pipeline graph, property values use gst-launch syntax
+type GraphElement struct {
+	Factory string
+	Name    string
+	Props   map[string]string
+}
+
+func NewGraphElement(factory string, name string, props map[string]string) *GraphElement {
+	if props == nil {
+		props = map[string]string{}
+	}
+
+	return &GraphElement{
+		Factory: factory,
+		Name:    name,
+		Props:   props,
+	}
+}
+
+// capsfilter element with given caps, e.g. video/x-raw,framerate=25/1
+func NewGraphCaps(name string, caps string) *GraphElement {
+	return NewGraphElement("capsfilter", name, map[string]string{"caps": caps})
+}
+
+func (el *GraphElement) Set(prop string, value any) {
+	el.Props[prop] = fmt.Sprint(value)
+}
+
+func (el *GraphElement) Get(prop string) (string, bool) {
+	value, ok := el.Props[prop]
+	return value, ok
+}
+
+// splits caps property to media type and fields, caps with nested lists or
+// multiple structures are not supported
+func (el *GraphElement) caps() (string, []string) {
+	parts := strings.Split(el.Props["caps"], ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	return parts[0], parts[1:]
+}
+
+// returns value of caps field without its type, e.g. 25/1 for framerate=(fraction)25/1
+func (el *GraphElement) CapsField(field string) (string, bool) {
+	_, fields := el.caps()
+	for _, f := range fields {
+		key, value, _ := strings.Cut(f, "=")
+		if strings.TrimSpace(key) != field {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "(") {
+			if i := strings.Index(value, ")"); i >= 0 {
+				value = strings.TrimSpace(value[i+1:])
+			}
+		}
+
+		return value, true
+	}
+
+	return "", false
+}
+
+// sets caps field of capsfilter element, it is appended if missing
+func (el *GraphElement) SetCapsField(field string, value any) {
+	mediaType, fields := el.caps()
+
+	entry := fmt.Sprintf("%s=%v", field, value)
+	found := false
+	for i, f := range fields {
+		if key, _, _ := strings.Cut(f, "="); strings.TrimSpace(key) == field {
+			fields[i] = entry
+			found = true
+		}
+	}
+
+	if !found {
+		fields = append(fields, entry)
+	}
+
+	el.Props["caps"] = strings.Join(append([]string{mediaType}, fields...), ",")
+}
+
+// serializes element to gst-launch syntax, properties are sorted so that
+// the same graph always produces the same string
+func (el *GraphElement) String() string {
+	parts := []string{el.Factory}
+	if el.Name != "" {
+		parts = append(parts, "name="+el.Name)
+	}
+
+	keys := make([]string, 0, len(el.Props))
+	for key := range el.Props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := el.Props[key]
+		quoted := len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`)
+		if !quoted && (value == "" || strings.ContainsAny(value, " \t\"")) {
+			value = `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+
+		parts = append(parts, key+"="+value)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// chain of elements linked in order. unlike pipeline string it can be changed
+// without parsing, it is serialized only when pipeline is created. branches,
+// e.g. for broadcast or hls, are still added to the serialized string.
+type PipelineGraph struct {
+	Elements []*GraphElement
+}
+
+func NewPipelineGraph(elements ...*GraphElement) *PipelineGraph {
+	return &PipelineGraph{
+		Elements: elements,
+	}
+}
+
+// returns element with given name, nil if not found
+func (graph *PipelineGraph) Find(name string) *GraphElement {
+	for _, el := range graph.Elements {
+		if el.Name == name {
+			return el
+		}
+	}
+
+	return nil
+}
+
+// inserts elements before i-th element, i equal to length appends them
+func (graph *PipelineGraph) Insert(i int, elements ...*GraphElement) {
+	graph.Elements = append(graph.Elements[:i], append(elements, graph.Elements[i:]...)...)
+}
+
+// returns first known encoder and its index
+func (graph *PipelineGraph) encoder() (int, *GraphElement, error) {
+	for i, el := range graph.Elements {
+		if _, ok := encoderBitrates[el.Factory]; ok {
+			return i, el, nil
+		}
+	}
+
+	return -1, nil, fmt.Errorf("%w: no known encoder found in pipeline", types.ErrPipelineParse)
+}
+
+// sets bitrate property of the encoder in kbit/s
+func (graph *PipelineGraph) SetBitrate(kbps int) error {
+	_, enc, err := graph.encoder()
+	if err != nil {
+		return err
+	}
+
+	bitrate := encoderBitrates[enc.Factory]
+	enc.Set(bitrate.prop, kbps*bitrate.scale)
+	return nil
+}
+
+func (graph *PipelineGraph) SetRateControl(mode types.RateControlMode) error {
+	_, enc, err := graph.encoder()
+	if err != nil {
+		return err
+	}
+
+	prop, value, _, err := encoderRateControlProp(enc.Factory, mode)
+	if err != nil {
+		return err
+	}
+
+	enc.Set(prop, value)
+	return nil
+}
+
+func (graph *PipelineGraph) SetEncoderPreset(preset string) error {
+	_, enc, err := graph.encoder()
+	if err != nil {
+		return err
+	}
+
+	prop, err := encoderPresetProp(enc.Factory, preset)
+	if err != nil {
+		return err
+	}
+
+	enc.Set(prop, preset)
+	return nil
+}
+
+func (graph *PipelineGraph) SetIntraRefresh() error {
+	_, enc, err := graph.encoder()
+	if err != nil {
+		return err
+	}
+
+	prop, err := encoderIntraRefreshProp(enc.Factory)
+	if err != nil {
+		return err
+	}
+
+	enc.Set(prop, true)
+	return nil
+}
+
+func (graph *PipelineGraph) SetKeyframeInterval(frames int) error {
+	_, enc, err := graph.encoder()
+	if err != nil {
+		return err
+	}
+
+	prop, _, err := encoderKeyframeIntervalProp(enc.Factory)
+	if err != nil {
+		return err
+	}
+
+	enc.Set(prop, frames)
+	return nil
+}
+
+func (graph *PipelineGraph) SetQP(min, max int) error {
+	_, enc, err := graph.encoder()
+	if err != nil {
+		return err
+	}
+
+	qp, err := encoderQPProps(enc.Factory, min, max)
+	if err != nil {
+		return err
+	}
+
+	enc.Set(qp.minProp, min)
+	enc.Set(qp.maxProp, max)
+	return nil
+}
+
+// sets framerate of all capsfilters having it, returns false if there is none
+func (graph *PipelineGraph) SetFramerate(framerate int16) bool {
+	found := false
+	for _, el := range graph.Elements {
+		if _, ok := el.CapsField("framerate"); ok {
+			el.SetCapsField("framerate", fmt.Sprintf("%d/1", framerate))
+			found = true
+		}
+	}
+
+	return found
+}
+
+// sets props of all queues and names unnamed ones, returns false if there is none
+func (graph *PipelineGraph) SetQueues(leaky string, maxBuffers int) bool {
+	found := 0
+	for _, el := range graph.Elements {
+		if el.Factory != "queue" {
+			continue
+		}
+
+		if el.Name == "" {
+			el.Name = fmt.Sprintf("%s%d", queueNamePrefix, found)
+		}
+		for prop, value := range queueProps(leaky, maxBuffers) {
+			el.Set(prop, value)
+		}
+		found++
+	}
+
+	return found > 0
+}
+
+// sets method of videoflip element, it is inserted before scaling or the encoder if missing
+func (graph *PipelineGraph) SetTransform(method string) error {
+	if el := graph.Find(transformName); el != nil {
+		el.Set("method", method)
+		return nil
+	}
+
+	i, _, err := graph.encoder()
+	if err != nil {
+		return err
+	}
+
+	for j, el := range graph.Elements[:i] {
+		if el.Name == resolutionCapsName {
+			i = j
+			if j > 0 && graph.Elements[j-1].Factory == "videoscale" {
+				i = j - 1
+			}
+			break
+		}
+	}
+
+	graph.Insert(i, NewGraphElement("videoflip", transformName, map[string]string{"method": method}))
+	return nil
+}
+
+// sets resolution of scaling capsfilter, it is inserted before the encoder if missing
+func (graph *PipelineGraph) SetResolution(width, height int) error {
+	if el := graph.Find(resolutionCapsName); el != nil {
+		el.SetCapsField("width", width)
+		el.SetCapsField("height", height)
+		return nil
+	}
+
+	i, _, err := graph.encoder()
+	if err != nil {
+		return err
+	}
+
+	graph.Insert(i,
+		NewGraphElement("videoscale", "", nil),
+		NewGraphCaps(resolutionCapsName, fmt.Sprintf("video/x-raw,width=%d,height=%d", width, height)),
+	)
+	return nil
+}
+
+// serializes graph to pipeline string accepted by gst.CreatePipeline
+func (graph *PipelineGraph) String() string {
+	elements := make([]string, len(graph.Elements))
+	for i, el := range graph.Elements {
+		elements[i] = el.String()
+	}
+
+	return strings.Join(elements, " ! ")
+}
@@ -14,7 +14,8 @@ static gboolean gstreamer_bus_call(GstBus *bus, GstMessage *msg, gpointer user_d
 
   switch (GST_MESSAGE_TYPE(msg)) {
     case GST_MESSAGE_EOS: {
-      gstreamer_pipeline_log(ctx, "fatal", "end of stream");
+      gstreamer_pipeline_log(ctx, "debug", "end of stream");
+      goHandlePipelineEOS(ctx->pipelineId);
       break;
     }
 
@@ -27,6 +28,11 @@ static gboolean gstreamer_bus_call(GstBus *bus, GstMessage *msg, gpointer user_d
           GST_OBJECT_NAME(msg->src),
           gst_element_state_get_name(old_state),
           gst_element_state_get_name(new_state));
+
+      // report only state of the whole pipeline, not of its elements
+      if (GST_MESSAGE_SRC(msg) == GST_OBJECT(ctx->pipeline)) {
+        goHandlePipelineStateChanged((char *) gst_element_state_get_name(new_state), ctx->pipelineId);
+      }
       break;
     }
 
@@ -54,6 +60,12 @@ static gboolean gstreamer_bus_call(GstBus *bus, GstMessage *msg, gpointer user_d
         "debugging info: %s",
           (dbg_info) ? dbg_info : "none");
 
+      gchar *message = g_strdup_printf("error from element %s: %s",
+          GST_OBJECT_NAME(msg->src), err->message);
+      // e.g. device is busy or missing
+      goHandlePipelineError(message, err->domain == GST_RESOURCE_ERROR, ctx->pipelineId);
+      g_free(message);
+
       g_error_free(err);
       g_free(dbg_info);
       break;
@@ -71,6 +83,13 @@ GstPipelineCtx *gstreamer_pipeline_create(char *pipelineStr, int pipelineId, GEr
   GstElement *pipeline = gst_parse_launch(pipelineStr, error);
   if (pipeline == NULL) return NULL;
 
+  // partially built pipeline is returned e.g. when an element is missing
+  if (*error != NULL) {
+    gst_object_ref_sink(pipeline);
+    gst_object_unref(pipeline);
+    return NULL;
+  }
+
   // create gstreamer pipeline context
   GstPipelineCtx *ctx = calloc(1, sizeof(GstPipelineCtx));
   ctx->pipelineId = pipelineId;
@@ -83,19 +102,47 @@ GstPipelineCtx *gstreamer_pipeline_create(char *pipelineStr, int pipelineId, GEr
   return ctx;
 }
 
+gboolean gstreamer_pipeline_validate(char *pipelineStr, GError **error) {
+  // without fatal errors flag, pipeline with missing elements would be returned
+  GstElement *pipeline = gst_parse_launch_full(pipelineStr, NULL, GST_PARSE_FLAG_FATAL_ERRORS, error);
+  if (pipeline == NULL) return FALSE;
+
+  // pipeline is never started, so no device is opened
+  gst_object_ref_sink(pipeline);
+  gst_object_unref(pipeline);
+  return *error == NULL;
+}
+
 static GstFlowReturn gstreamer_send_new_sample_handler(GstElement *object, gpointer user_data) {
   GstPipelineCtx *ctx = (GstPipelineCtx *)user_data;
   GstSample *sample = NULL;
   GstBuffer *buffer = NULL;
-  gpointer copy = NULL;
-  gsize copy_size = 0;
+  int sinkId = 0;
+
+  for (int i = 0; i < ctx->appsinksCount; i++) {
+    if (ctx->appsinks[i] == object) {
+      sinkId = i;
+      break;
+    }
+  }
 
   g_signal_emit_by_name(object, "pull-sample", &sample);
   if (sample) {
     buffer = gst_sample_get_buffer(sample);
-    if (buffer) {
-      gst_buffer_extract_dup(buffer, 0, gst_buffer_get_size(buffer), &copy, &copy_size);
-      goHandlePipelineBuffer(copy, copy_size, GST_BUFFER_DURATION(buffer), ctx->pipelineId);
+    // buffer is mapped instead of copied, go either copies it once or leases it
+    GstBufferLease *lease = buffer ? g_new0(GstBufferLease, 1) : NULL;
+    if (lease && !gst_buffer_map(buffer, &lease->map, GST_MAP_READ)) {
+      g_free(lease);
+      lease = NULL;
+    }
+    if (lease) {
+      lease->buffer = gst_buffer_ref(buffer);
+      // unknown times are reported as -1
+      gint64 duration = GST_BUFFER_DURATION_IS_VALID(buffer) ? (gint64) GST_BUFFER_DURATION(buffer) : -1;
+      gint64 pts = GST_BUFFER_PTS_IS_VALID(buffer) ? (gint64) GST_BUFFER_PTS(buffer) : -1;
+      // buffers that can be decoded on their own are not marked as delta units
+      int keyframe = !GST_BUFFER_FLAG_IS_SET(buffer, GST_BUFFER_FLAG_DELTA_UNIT);
+      goHandlePipelineBuffer(lease, lease->map.data, lease->map.size, duration, pts, keyframe, ctx->pipelineId, sinkId);
     }
     gst_sample_unref(sample);
   }
@@ -103,22 +150,34 @@ static GstFlowReturn gstreamer_send_new_sample_handler(GstElement *object, gpoin
   return GST_FLOW_OK;
 }
 
-void gstreamer_pipeline_attach_appsink(GstPipelineCtx *ctx, char *sinkName) {
-  ctx->appsink = gst_bin_get_by_name(GST_BIN(ctx->pipeline), sinkName);
-  g_object_set(ctx->appsink, "emit-signals", TRUE, NULL);
-  g_signal_connect(ctx->appsink, "new-sample", G_CALLBACK(gstreamer_send_new_sample_handler), ctx);
+gboolean gstreamer_pipeline_attach_appsink(GstPipelineCtx *ctx, char *sinkName) {
+  if (ctx->appsinksCount >= GST_PIPELINE_MAX_APPSINKS) return FALSE;
+
+  GstElement *appsink = gst_bin_get_by_name(GST_BIN(ctx->pipeline), sinkName);
+  if (appsink == NULL) return FALSE;
+
+  ctx->appsinks[ctx->appsinksCount++] = appsink;
+  g_object_set(appsink, "emit-signals", TRUE, NULL);
+  g_signal_connect(appsink, "new-sample", G_CALLBACK(gstreamer_send_new_sample_handler), ctx);
+  return TRUE;
 }
 
 void gstreamer_pipeline_attach_appsrc(GstPipelineCtx *ctx, char *srcName) {
   ctx->appsrc = gst_bin_get_by_name(GST_BIN(ctx->pipeline), srcName);
 }
 
-void gstreamer_pipeline_play(GstPipelineCtx *ctx) {
-  gst_element_set_state(GST_ELEMENT(ctx->pipeline), GST_STATE_PLAYING);
+void gstreamer_buffer_lease_release(GstBufferLease *lease) {
+  gst_buffer_unmap(lease->buffer, &lease->map);
+  gst_buffer_unref(lease->buffer);
+  g_free(lease);
 }
 
-void gstreamer_pipeline_pause(GstPipelineCtx *ctx) {
-  gst_element_set_state(GST_ELEMENT(ctx->pipeline), GST_STATE_PAUSED);
+gboolean gstreamer_pipeline_play(GstPipelineCtx *ctx) {
+  return gst_element_set_state(GST_ELEMENT(ctx->pipeline), GST_STATE_PLAYING) != GST_STATE_CHANGE_FAILURE;
+}
+
+gboolean gstreamer_pipeline_pause(GstPipelineCtx *ctx) {
+  return gst_element_set_state(GST_ELEMENT(ctx->pipeline), GST_STATE_PAUSED) != GST_STATE_CHANGE_FAILURE;
 }
 
 void gstreamer_pipeline_destory(GstPipelineCtx *ctx) {
@@ -133,10 +192,11 @@ void gstreamer_pipeline_destory(GstPipelineCtx *ctx) {
   // set null state
   gst_element_set_state(GST_ELEMENT(ctx->pipeline), GST_STATE_NULL);
 
-  if (ctx->appsink) {
-    gst_object_unref(ctx->appsink);
-    ctx->appsink = NULL;
+  for (int i = 0; i < ctx->appsinksCount; i++) {
+    gst_object_unref(ctx->appsinks[i]);
+    ctx->appsinks[i] = NULL;
   }
+  ctx->appsinksCount = 0;
 
   if (ctx->appsrc) {
     gst_object_unref(ctx->appsrc);
@@ -166,6 +226,64 @@ gboolean gstreamer_pipeline_set_prop_int(GstPipelineCtx *ctx, char *binName, cha
   return TRUE;
 }
 
+gboolean gstreamer_pipeline_set_prop_str(GstPipelineCtx *ctx, char *binName, char *prop, char *value) {
+  GstElement *el = gst_bin_get_by_name(GST_BIN(ctx->pipeline), binName);
+  if (el == NULL) return FALSE;
+
+  if (g_object_class_find_property(G_OBJECT_GET_CLASS(el), prop) == NULL) {
+    gst_object_unref(el);
+    return FALSE;
+  }
+
+  // value is deserialized to the property type
+  gst_util_set_object_arg(G_OBJECT(el), prop, value);
+
+  gst_object_unref(el);
+  return TRUE;
+}
+
+gchar *gstreamer_pipeline_get_prop_str(GstPipelineCtx *ctx, char *binName, char *prop) {
+  GstElement *el = gst_bin_get_by_name(GST_BIN(ctx->pipeline), binName);
+  if (el == NULL) return NULL;
+
+  GParamSpec *spec = g_object_class_find_property(G_OBJECT_GET_CLASS(el), prop);
+  if (spec == NULL) {
+    gst_object_unref(el);
+    return NULL;
+  }
+
+  GValue value = G_VALUE_INIT;
+  g_value_init(&value, spec->value_type);
+  g_object_get_property(G_OBJECT(el), prop, &value);
+
+  gchar *str = gst_value_serialize(&value);
+  if (str == NULL) {
+    str = g_strdup_value_contents(&value);
+  }
+
+  g_value_unset(&value);
+  gst_object_unref(el);
+  return str;
+}
+
+gchar *gstreamer_pipeline_get_pad_caps(GstPipelineCtx *ctx, char *binName, char *padName) {
+  GstElement *el = gst_bin_get_by_name(GST_BIN(ctx->pipeline), binName);
+  if (el == NULL) return NULL;
+
+  GstPad *pad = gst_element_get_static_pad(el, padName);
+  gst_object_unref(el);
+  if (pad == NULL) return NULL;
+
+  // caps are set on the pad only after they were negotiated
+  GstCaps *caps = gst_pad_get_current_caps(pad);
+  gst_object_unref(pad);
+  if (caps == NULL) return NULL;
+
+  gchar *str = gst_caps_to_string(caps);
+  gst_caps_unref(caps);
+  return str;
+}
+
 gboolean gstreamer_pipeline_set_caps_framerate(GstPipelineCtx *ctx, const gchar* binName, gint numerator, gint denominator) {
   GstElement *el = gst_bin_get_by_name(GST_BIN(ctx->pipeline), binName);
   if (el == NULL) return FALSE;
@@ -200,3 +318,139 @@ gboolean gstreamer_pipeline_set_caps_resolution(GstPipelineCtx *ctx, const gchar
   gst_object_unref(el);
   return TRUE;
 }
+
+gboolean gstreamer_pipeline_force_key_unit(GstPipelineCtx *ctx) {
+  // upstream events sent to a bin are dispatched to all its sinks, from there
+  // they travel upstream until the encoder handles them
+  GstEvent *event = gst_event_new_custom(GST_EVENT_CUSTOM_UPSTREAM,
+    gst_structure_new("GstForceKeyUnit",
+      "running-time", GST_TYPE_CLOCK_TIME, GST_CLOCK_TIME_NONE,
+      "all-headers", G_TYPE_BOOLEAN, TRUE,
+      NULL));
+
+  return gst_element_send_event(GST_ELEMENT(ctx->pipeline), event);
+}
+
+const char *gstreamer_pipeline_get_state(GstPipelineCtx *ctx) {
+  GstState state = GST_STATE_VOID_PENDING;
+  gst_element_get_state(GST_ELEMENT(ctx->pipeline), &state, NULL, 0);
+  return gst_element_state_get_name(state);
+}
+
+static GstPadProbeReturn gstreamer_branch_eos_probe(GstPad *pad, GstPadProbeInfo *info, gpointer user_data) {
+  if (GST_EVENT_TYPE(GST_PAD_PROBE_INFO_EVENT(info)) != GST_EVENT_EOS)
+    return GST_PAD_PROBE_OK;
+
+  goHandlePipelineBranchEOS(GST_OBJECT_NAME(GST_PAD_PARENT(pad)), GPOINTER_TO_INT(user_data));
+  return GST_PAD_PROBE_REMOVE;
+}
+
+gboolean gstreamer_pipeline_end_branch(GstPipelineCtx *ctx, char *srcName, char *sinkName) {
+  GstElement *src = gst_bin_get_by_name(GST_BIN(ctx->pipeline), srcName);
+  if (src == NULL) return FALSE;
+
+  GstElement *sink = gst_bin_get_by_name(GST_BIN(ctx->pipeline), sinkName);
+  if (sink == NULL) {
+    gst_object_unref(src);
+    return FALSE;
+  }
+
+  // report when eos reaches the sink, pipeline bus gets eos only from all sinks
+  GstPad *pad = gst_element_get_static_pad(sink, "sink");
+  if (pad != NULL) {
+    gst_pad_add_probe(pad, GST_PAD_PROBE_TYPE_EVENT_DOWNSTREAM,
+      gstreamer_branch_eos_probe, GINT_TO_POINTER(ctx->pipelineId), NULL);
+    gst_object_unref(pad);
+  }
+  gst_object_unref(sink);
+
+  // downstream event sent to an element is pushed to its sink pad
+  gboolean ok = pad != NULL && gst_element_send_event(src, gst_event_new_eos());
+  gst_object_unref(src);
+  return ok;
+}
+
+gboolean gstreamer_pipeline_send_eos(GstPipelineCtx *ctx) {
+  return gst_element_send_event(GST_ELEMENT(ctx->pipeline), gst_event_new_eos());
+}
+
+gint64 gstreamer_pipeline_query_bytes(GstPipelineCtx *ctx, char *binName) {
+  GstElement *el = gst_bin_get_by_name(GST_BIN(ctx->pipeline), binName);
+  if (el == NULL) return -1;
+
+  gint64 position = -1;
+  if (!gst_element_query_position(el, GST_FORMAT_BYTES, &position)) {
+    position = -1;
+  }
+
+  gst_object_unref(el);
+  return position;
+}
+
+gint64 gstreamer_pipeline_query_latency(GstPipelineCtx *ctx) {
+  GstQuery *query = gst_query_new_latency();
+  gint64 latency = -1;
+
+  if (gst_element_query(GST_ELEMENT(ctx->pipeline), query)) {
+    gboolean live;
+    GstClockTime min, max;
+    gst_query_parse_latency(query, &live, &min, &max);
+
+    // minimum latency is the delay the pipeline adds to live data
+    if (GST_CLOCK_TIME_IS_VALID(min)) {
+      latency = (gint64) min;
+    }
+  }
+
+  gst_query_unref(query);
+  return latency;
+}
+
+gchar *gstreamer_pipeline_dump_dot(GstPipelineCtx *ctx) {
+  GstBin *bin = GST_BIN(ctx->pipeline);
+
+  // written to GST_DEBUG_DUMP_DOT_DIR as well, does nothing if it is not set
+  gchar *fileName = g_strdup_printf("neko-pipeline-%d", ctx->pipelineId);
+  GST_DEBUG_BIN_TO_DOT_FILE_WITH_TS(bin, GST_DEBUG_GRAPH_SHOW_ALL, fileName);
+  g_free(fileName);
+
+  return gst_debug_bin_to_dot_data(bin, GST_DEBUG_GRAPH_SHOW_ALL);
+}
+
+static GstPadProbeReturn gstreamer_rebase_probe(GstPad *pad, GstPadProbeInfo *info, gpointer user_data) {
+  GstClockTime *base = (GstClockTime *) user_data;
+  GstBuffer *buf = GST_PAD_PROBE_INFO_BUFFER(info);
+
+  // first buffer with timestamp becomes zero
+  if (!GST_CLOCK_TIME_IS_VALID(*base)) {
+    if (!GST_BUFFER_PTS_IS_VALID(buf)) return GST_PAD_PROBE_OK;
+    *base = GST_BUFFER_PTS(buf);
+  }
+
+  buf = gst_buffer_make_writable(buf);
+  if (GST_BUFFER_PTS_IS_VALID(buf)) {
+    GST_BUFFER_PTS(buf) = GST_BUFFER_PTS(buf) > *base ? GST_BUFFER_PTS(buf) - *base : 0;
+  }
+  if (GST_BUFFER_DTS_IS_VALID(buf)) {
+    GST_BUFFER_DTS(buf) = GST_BUFFER_DTS(buf) > *base ? GST_BUFFER_DTS(buf) - *base : 0;
+  }
+
+  GST_PAD_PROBE_INFO_DATA(info) = buf;
+  return GST_PAD_PROBE_OK;
+}
+
+gboolean gstreamer_pipeline_rebase_timestamps(GstPipelineCtx *ctx, char *binName) {
+  GstElement *el = gst_bin_get_by_name(GST_BIN(ctx->pipeline), binName);
+  if (el == NULL) return FALSE;
+
+  GstPad *pad = gst_element_get_static_pad(el, "src");
+  gst_object_unref(el);
+  if (pad == NULL) return FALSE;
+
+  GstClockTime *base = g_new(GstClockTime, 1);
+  *base = GST_CLOCK_TIME_NONE;
+
+  gst_pad_add_probe(pad, GST_PAD_PROBE_TYPE_BUFFER, gstreamer_rebase_probe, base, g_free);
+  gst_object_unref(pad);
+  return TRUE;
+}
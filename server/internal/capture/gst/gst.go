@@ -7,12 +7,14 @@ package gst
 */
 import "C"
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
+	"github.com/pion/webrtc/v3/pkg/media"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
@@ -20,13 +22,75 @@ import (
 )
 
 type Pipeline struct {
-	id     int
-	logger zerolog.Logger
-	Src    string
-	Ctx    *C.GstPipelineCtx
-	Sample chan types.Sample
+	id      int
+	logger  zerolog.Logger
+	src     string
+	Ctx     *C.GstPipelineCtx
+	Samples []chan types.Sample // indexed by order of attached appsinks
+	errs    chan error
+	// used by streaming thread of appsink with the same index, reused for every sample
+	sendTimers []*time.Timer
+
+	// closed when eos reaches sink of ended branch, guarded by pipelinesLock
+	branchesEOS map[string]chan struct{}
+	// closed when eos reaches all sinks after it was requested, guarded by pipelinesLock
+	eos chan struct{}
+
+	// closed when pipeline reaches playing state for the first time
+	playing       chan struct{}
+	playingClosed bool // guarded by pipelinesLock
+	// closed when pipeline is destroyed
+	destroyed chan struct{}
+
+	// full sample channel drops oldest sample instead of waiting for space
+	latestOnly atomic.Bool
+	// samples reference mapped buffers instead of copies
+	leaseBuffers atomic.Bool
+	// counts samples dropped because sample channel was full, nil if not counted
+	dropped atomic.Pointer[atomic.Uint64]
 }
 
+// keeps gstreamer buffer mapped until the last reference is released
+type bufferLease struct {
+	lease *C.GstBufferLease
+	refs  atomic.Int32
+	// extra releases are reported only once
+	overReleased atomic.Bool
+}
+
+func newBufferLease(lease *C.GstBufferLease) *bufferLease {
+	l := &bufferLease{lease: lease}
+	l.refs.Store(1)
+	return l
+}
+
+func (l *bufferLease) Retain() {
+	l.refs.Add(1)
+}
+
+func (l *bufferLease) Release() {
+	refs := l.refs.Add(-1)
+	if refs < 0 {
+		// buffer was already released, extra release is a bug of consumer
+		if l.overReleased.CompareAndSwap(false, true) {
+			log.Error().
+				Str("module", "capture").
+				Str("submodule", "gstreamer").
+				Msgf("sample lease released more times than retained, ignoring")
+		}
+		return
+	}
+
+	if refs == 0 {
+		C.gstreamer_buffer_lease_release(l.lease)
+		l.lease = nil
+	}
+}
+
+// how long streaming thread waits for space in sample channel before sample is
+// dropped, short so that slow consumer does not stall the pipeline
+const sampleSendTimeout = 20 * time.Millisecond
+
 var pSerial int32
 var pipelines = make(map[int]*Pipeline)
 var pipelinesLock sync.Mutex
@@ -68,8 +132,7 @@ func CreatePipeline(pipelineStr string) (*Pipeline, error) {
 
 	if gstError != nil {
 		defer C.g_error_free(gstError)
-		fmt.Printf("(pipeline error) %s", C.GoString(gstError.message))
-		return nil, fmt.Errorf("(pipeline error) %s", C.GoString(gstError.message))
+		return nil, parseError(gstError)
 	}
 
 	p := &Pipeline{
@@ -78,21 +141,67 @@ func CreatePipeline(pipelineStr string) (*Pipeline, error) {
 			Str("module", "capture").
 			Str("submodule", "gstreamer").
 			Int("pipeline_id", int(id)).Logger(),
-		Src: pipelineStr,
-		Ctx: ctx,
+		src:  pipelineStr,
+		Ctx:  ctx,
+		errs: make(chan error, 1),
+
+		branchesEOS: map[string]chan struct{}{},
+		playing:     make(chan struct{}),
+		destroyed:   make(chan struct{}),
 	}
 
 	pipelines[p.id] = p
 	return p, nil
 }
 
-func (p *Pipeline) AttachAppsink(sinkName string, sampleChannel chan types.Sample) {
+// parses pipeline string and destroys it right away without starting it,
+// returns parse errors including missing elements
+func ValidatePipeline(pipelineStr string) error {
+	pipelineStrUnsafe := C.CString(pipelineStr)
+	defer C.free(unsafe.Pointer(pipelineStrUnsafe))
+
+	var gstError *C.GError
+	ok := C.gstreamer_pipeline_validate(pipelineStrUnsafe, &gstError)
+
+	if gstError != nil {
+		defer C.g_error_free(gstError)
+		return parseError(gstError)
+	}
+
+	if ok != C.TRUE {
+		return fmt.Errorf("%w: unable to parse pipeline", types.ErrPipelineParse)
+	}
+
+	return nil
+}
+
+// missing element means its plugin is not installed, not that pipeline is invalid
+func parseError(gstError *C.GError) error {
+	message := C.GoString(gstError.message)
+
+	if gstError.domain == C.gst_parse_error_quark() && gstError.code == C.gint(C.GST_PARSE_ERROR_NO_SUCH_ELEMENT) {
+		return fmt.Errorf("%w: %s", types.ErrPipelineResource, message)
+	}
+
+	return fmt.Errorf("%w: %s", types.ErrPipelineParse, message)
+}
+
+func (p *Pipeline) AttachAppsink(sinkName string, sampleChannel chan types.Sample) error {
 	sinkNameUnsafe := C.CString(sinkName)
 	defer C.free(unsafe.Pointer(sinkNameUnsafe))
 
-	p.Sample = sampleChannel
+	ok := C.gstreamer_pipeline_attach_appsink(p.Ctx, sinkNameUnsafe)
+	if ok != C.TRUE {
+		return fmt.Errorf("%w: unable to attach appsink %s", types.ErrPipelineParse, sinkName)
+	}
+
+	// timer is created stopped, so that its channel is empty
+	timer := time.NewTimer(sampleSendTimeout)
+	timer.Stop()
 
-	C.gstreamer_pipeline_attach_appsink(p.Ctx, sinkNameUnsafe)
+	p.Samples = append(p.Samples, sampleChannel)
+	p.sendTimers = append(p.sendTimers, timer)
+	return nil
 }
 
 func (p *Pipeline) AttachAppsrc(srcName string) {
@@ -102,12 +211,64 @@ func (p *Pipeline) AttachAppsrc(srcName string) {
 	C.gstreamer_pipeline_attach_appsrc(p.Ctx, srcNameUnsafe)
 }
 
-func (p *Pipeline) Play() {
-	C.gstreamer_pipeline_play(p.Ctx)
+func (p *Pipeline) Play() error {
+	if C.gstreamer_pipeline_play(p.Ctx) != C.TRUE {
+		return fmt.Errorf("%w: unable to set pipeline to playing state", types.ErrPipelinePlay)
+	}
+
+	return nil
+}
+
+func (p *Pipeline) Pause() error {
+	if C.gstreamer_pipeline_pause(p.Ctx) != C.TRUE {
+		return fmt.Errorf("%w: unable to set pipeline to paused state", types.ErrPipelinePlay)
+	}
+
+	return nil
+}
+
+// when enabled, oldest buffered sample is dropped in favor of the newest one when
+// sample channel is full, keeping latency low. otherwise samples are delivered
+// in order and dropped only if channel stays full for a long time.
+func (p *Pipeline) SetLatestOnly(enabled bool) {
+	p.latestOnly.Store(enabled)
+}
+
+// when enabled, sample data references mapped gstreamer buffer instead of its
+// copy, every received sample must then be released, see types.Sample.Release
+func (p *Pipeline) SetLeaseBuffers(enabled bool) {
+	p.leaseBuffers.Store(enabled)
+}
+
+// samples dropped because sample channel was full are added to counter
+func (p *Pipeline) SetDroppedCounter(counter *atomic.Uint64) {
+	p.dropped.Store(counter)
+}
+
+// returns pipeline string the pipeline was created from
+func (p *Pipeline) Src() string {
+	return p.src
 }
 
-func (p *Pipeline) Pause() {
-	C.gstreamer_pipeline_pause(p.Ctx)
+// updates pipeline string after properties were changed in place, so that
+// recreated pipeline keeps them
+func (p *Pipeline) SetSrc(src string) {
+	p.src = src
+}
+
+// returns channel with errors reported on the bus, closed when pipeline is destroyed
+func (p *Pipeline) Errors() <-chan error {
+	return p.errs
+}
+
+// returns channel closed when pipeline actually reaches playing state, that
+// happens asynchronously after Play
+func (p *Pipeline) Playing() <-chan struct{} {
+	return p.playing
+}
+
+func (p *Pipeline) Destroyed() <-chan struct{} {
+	return p.destroyed
 }
 
 func (p *Pipeline) Destroy() {
@@ -115,6 +276,8 @@ func (p *Pipeline) Destroy() {
 
 	pipelinesLock.Lock()
 	delete(pipelines, p.id)
+	close(p.errs)
+	close(p.destroyed)
 	pipelinesLock.Unlock()
 
 	C.free(unsafe.Pointer(p.Ctx))
@@ -143,6 +306,57 @@ func (p *Pipeline) SetPropInt(binName string, prop string, value int) bool {
 	return ok == C.TRUE
 }
 
+// sets property of any type from its string representation
+func (p *Pipeline) SetPropString(binName string, prop string, value string) bool {
+	cBinName := C.CString(binName)
+	defer C.free(unsafe.Pointer(cBinName))
+
+	cProp := C.CString(prop)
+	defer C.free(unsafe.Pointer(cProp))
+
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+
+	p.logger.Debug().Msgf("setting prop %s of %s to %s", prop, binName, value)
+
+	ok := C.gstreamer_pipeline_set_prop_str(p.Ctx, cBinName, cProp, cValue)
+	return ok == C.TRUE
+}
+
+// gets property of any type serialized to string
+func (p *Pipeline) GetPropString(binName string, prop string) (string, bool) {
+	cBinName := C.CString(binName)
+	defer C.free(unsafe.Pointer(cBinName))
+
+	cProp := C.CString(prop)
+	defer C.free(unsafe.Pointer(cProp))
+
+	cValue := C.gstreamer_pipeline_get_prop_str(p.Ctx, cBinName, cProp)
+	if cValue == nil {
+		return "", false
+	}
+	defer C.g_free(C.gpointer(unsafe.Pointer(cValue)))
+
+	return C.GoString((*C.char)(unsafe.Pointer(cValue))), true
+}
+
+// returns caps negotiated on static pad of element, false if they are not negotiated yet
+func (p *Pipeline) GetPadCaps(binName string, padName string) (string, bool) {
+	cBinName := C.CString(binName)
+	defer C.free(unsafe.Pointer(cBinName))
+
+	cPadName := C.CString(padName)
+	defer C.free(unsafe.Pointer(cPadName))
+
+	cCaps := C.gstreamer_pipeline_get_pad_caps(p.Ctx, cBinName, cPadName)
+	if cCaps == nil {
+		return "", false
+	}
+	defer C.g_free(C.gpointer(unsafe.Pointer(cCaps)))
+
+	return C.GoString((*C.char)(unsafe.Pointer(cCaps))), true
+}
+
 func (p *Pipeline) SetCapsFramerate(binName string, numerator, denominator int) bool {
 	cBinName := C.CString(binName)
 	cNumerator := C.int(numerator)
@@ -169,6 +383,133 @@ func (p *Pipeline) SetCapsResolution(binName string, width, height int) bool {
 	return ok == C.TRUE
 }
 
+func (p *Pipeline) ForceKeyUnit() bool {
+	p.logger.Debug().Msgf("forcing key unit")
+
+	ok := C.gstreamer_pipeline_force_key_unit(p.Ctx)
+	return ok == C.TRUE
+}
+
+func (p *Pipeline) State() string {
+	return C.GoString(C.gstreamer_pipeline_get_state(p.Ctx))
+}
+
+// sends end of stream to srcName and waits until it reaches sinkName, so that
+// the branch between them is finalized while rest of the pipeline keeps running
+func (p *Pipeline) EndBranch(srcName string, sinkName string, timeout time.Duration) error {
+	cSrcName := C.CString(srcName)
+	defer C.free(unsafe.Pointer(cSrcName))
+
+	cSinkName := C.CString(sinkName)
+	defer C.free(unsafe.Pointer(cSinkName))
+
+	done := make(chan struct{})
+
+	pipelinesLock.Lock()
+	p.branchesEOS[sinkName] = done
+	pipelinesLock.Unlock()
+
+	defer func() {
+		pipelinesLock.Lock()
+		delete(p.branchesEOS, sinkName)
+		pipelinesLock.Unlock()
+	}()
+
+	p.logger.Debug().Msgf("ending branch from %s to %s", srcName, sinkName)
+
+	if C.gstreamer_pipeline_end_branch(p.Ctx, cSrcName, cSinkName) != C.TRUE {
+		return fmt.Errorf("unable to end branch from %s to %s", srcName, sinkName)
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timeout while waiting for end of stream in %s", sinkName)
+	}
+}
+
+// sends end of stream to the whole pipeline and waits until it reaches all sinks,
+// so that muxers can finalize their output before the pipeline is destroyed
+func (p *Pipeline) EndOfStream(timeout time.Duration) error {
+	done := make(chan struct{})
+
+	pipelinesLock.Lock()
+	p.eos = done
+	pipelinesLock.Unlock()
+
+	defer func() {
+		pipelinesLock.Lock()
+		p.eos = nil
+		pipelinesLock.Unlock()
+	}()
+
+	p.logger.Debug().Msgf("sending end of stream")
+
+	if C.gstreamer_pipeline_send_eos(p.Ctx) != C.TRUE {
+		return errors.New("unable to send end of stream")
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timeout while waiting for end of stream")
+	}
+}
+
+// returns number of bytes processed by element, false if it cannot tell
+func (p *Pipeline) QueryBytes(binName string) (int64, bool) {
+	cBinName := C.CString(binName)
+	defer C.free(unsafe.Pointer(cBinName))
+
+	bytes := int64(C.gstreamer_pipeline_query_bytes(p.Ctx, cBinName))
+	return bytes, bytes >= 0
+}
+
+// returns minimum latency reported by latency query of the whole pipeline,
+// false if it cannot tell, e.g. because it is not playing
+func (p *Pipeline) QueryLatency() (time.Duration, bool) {
+	latency := int64(C.gstreamer_pipeline_query_latency(p.Ctx))
+	return time.Duration(latency), latency >= 0
+}
+
+// shifts timestamps of buffers leaving element, so that the first one starts
+// at zero, e.g. for branch that is added to running stream
+func (p *Pipeline) RebaseTimestamps(binName string) bool {
+	cBinName := C.CString(binName)
+	defer C.free(unsafe.Pointer(cBinName))
+
+	return C.gstreamer_pipeline_rebase_timestamps(p.Ctx, cBinName) == C.TRUE
+}
+
+// returns current topology of the pipeline in graphviz dot format, including
+// negotiated caps and properties of elements
+func (p *Pipeline) DumpDot() (string, bool) {
+	cDot := C.gstreamer_pipeline_dump_dot(p.Ctx)
+	if cDot == nil {
+		return "", false
+	}
+	defer C.g_free(C.gpointer(unsafe.Pointer(cDot)))
+
+	return C.GoString((*C.char)(unsafe.Pointer(cDot))), true
+}
+
+// checks that element factory is registered, hardware encoders are registered
+// only when their device is available
+func CheckElement(name string) bool {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	factory := C.gst_element_factory_find(cName)
+	if factory == nil {
+		return false
+	}
+
+	C.gst_object_unref(C.gpointer(factory))
+	return true
+}
+
 // gst-inspect-1.0
 func CheckPlugins(plugins []string) error {
 	var plugin *C.GstPlugin
@@ -177,7 +518,7 @@ func CheckPlugins(plugins []string) error {
 		plugin = C.gst_registry_find_plugin(registry, plugincstr)
 		C.free(unsafe.Pointer(plugincstr))
 		if plugin == nil {
-			return fmt.Errorf("required gstreamer plugin %s not found", pluginstr)
+			return fmt.Errorf("%w: required gstreamer plugin %s not found", types.ErrPipelineResource, pluginstr)
 		}
 	}
 
@@ -185,20 +526,40 @@ func CheckPlugins(plugins []string) error {
 }
 
 //export goHandlePipelineBuffer
-func goHandlePipelineBuffer(buffer unsafe.Pointer, bufferLen C.int, duration C.int, pipelineID C.int) {
-	defer C.free(buffer)
-
+func goHandlePipelineBuffer(lease *C.GstBufferLease, data unsafe.Pointer, dataLen C.int, duration C.gint64, pts C.gint64, keyframe C.int, pipelineID C.int, sinkID C.int) {
 	pipelinesLock.Lock()
 	pipeline, ok := pipelines[int(pipelineID)]
 	pipelinesLock.Unlock()
 
-	if ok {
-		pipeline.Sample <- types.Sample{
-			Data:      C.GoBytes(buffer, bufferLen),
-			Timestamp: time.Now(),
-			Duration:  time.Duration(duration),
+	if ok && int(sinkID) < len(pipeline.Samples) {
+		sample := types.Sample{
+			Sample: media.Sample{
+				Timestamp: time.Now(),
+			},
+			PTS:      -1,
+			Keyframe: keyframe != 0,
+		}
+
+		if pipeline.leaseBuffers.Load() {
+			sample.Data = unsafe.Slice((*byte)(data), int(dataLen))
+			sample.Lease = newBufferLease(lease)
+		} else {
+			sample.Data = C.GoBytes(data, dataLen)
+			C.gstreamer_buffer_lease_release(lease)
+		}
+
+		if duration >= 0 {
+			sample.Duration = time.Duration(duration)
 		}
+
+		if pts >= 0 {
+			sample.PTS = time.Duration(pts)
+		}
+
+		pipeline.sendSample(int(sinkID), sample)
 	} else {
+		C.gstreamer_buffer_lease_release(lease)
+
 		log.Warn().
 			Str("module", "capture").
 			Str("submodule", "gstreamer").
@@ -207,6 +568,137 @@ func goHandlePipelineBuffer(buffer unsafe.Pointer, bufferLen C.int, duration C.i
 	}
 }
 
+// never blocks streaming thread for long, it would stall the pipeline and its destruction
+func (p *Pipeline) sendSample(sinkID int, sample types.Sample) {
+	samples := p.Samples[sinkID]
+
+	select {
+	case samples <- sample:
+		return
+	default:
+	}
+
+	if p.latestOnly.Load() {
+		for {
+			// make room by dropping oldest, emit goroutine may take it meanwhile
+			select {
+			case oldest := <-samples:
+				oldest.Release()
+				p.countDropped()
+				p.logger.Trace().Msg("sample channel is full, dropping oldest sample")
+			default:
+			}
+
+			select {
+			case samples <- sample:
+				return
+			default:
+			}
+		}
+	}
+
+	timer := p.sendTimers[sinkID]
+	timer.Reset(sampleSendTimeout)
+
+	select {
+	case samples <- sample:
+		if !timer.Stop() {
+			<-timer.C
+		}
+	case <-timer.C:
+		sample.Release()
+		p.countDropped()
+		p.logger.Debug().Msg("sample channel is full, dropping sample")
+	}
+}
+
+func (p *Pipeline) countDropped() {
+	if counter := p.dropped.Load(); counter != nil {
+		counter.Add(1)
+	}
+}
+
+//export goHandlePipelineError
+func goHandlePipelineError(messageUnsafe *C.char, resource C.int, pipelineID C.int) {
+	pipelinesLock.Lock()
+	defer pipelinesLock.Unlock()
+
+	pipeline, ok := pipelines[int(pipelineID)]
+	if !ok {
+		return
+	}
+
+	err := errors.New(C.GoString(messageUnsafe))
+	if resource != 0 {
+		err = fmt.Errorf("%w: %s", types.ErrPipelineResource, err)
+	}
+
+	// keep only the first unread error, do not block the main loop
+	select {
+	case pipeline.errs <- err:
+	default:
+	}
+}
+
+//export goHandlePipelineBranchEOS
+func goHandlePipelineBranchEOS(sinkNameUnsafe *C.char, pipelineID C.int) {
+	pipelinesLock.Lock()
+	defer pipelinesLock.Unlock()
+
+	pipeline, ok := pipelines[int(pipelineID)]
+	if !ok {
+		return
+	}
+
+	sinkName := C.GoString(sinkNameUnsafe)
+	if done, ok := pipeline.branchesEOS[sinkName]; ok {
+		close(done)
+		delete(pipeline.branchesEOS, sinkName)
+	}
+}
+
+//export goHandlePipelineEOS
+func goHandlePipelineEOS(pipelineID C.int) {
+	pipelinesLock.Lock()
+	defer pipelinesLock.Unlock()
+
+	pipeline, ok := pipelines[int(pipelineID)]
+	if !ok {
+		return
+	}
+
+	// end of stream was requested, pipeline is being finalized
+	if pipeline.eos != nil {
+		close(pipeline.eos)
+		pipeline.eos = nil
+		return
+	}
+
+	pipeline.logger.Error().Msg("unexpected end of stream")
+
+	// keep only the first unread error, do not block the main loop
+	select {
+	case pipeline.errs <- errors.New("unexpected end of stream"):
+	default:
+	}
+}
+
+//export goHandlePipelineStateChanged
+func goHandlePipelineStateChanged(stateUnsafe *C.char, pipelineID C.int) {
+	pipelinesLock.Lock()
+	defer pipelinesLock.Unlock()
+
+	pipeline, ok := pipelines[int(pipelineID)]
+	if !ok {
+		return
+	}
+
+	if C.GoString(stateUnsafe) == "PLAYING" && !pipeline.playingClosed {
+		pipeline.playingClosed = true
+		close(pipeline.playing)
+	}
+}
+
 //export goPipelineLog
 func goPipelineLog(levelUnsafe *C.char, msgUnsafe *C.char, pipelineID C.int) {
 	levelStr := C.GoString(levelUnsafe)
@@ -4,24 +4,50 @@
 #include <gst/gst.h>
 #include <gst/app/gstappsrc.h>
 
+#define GST_PIPELINE_MAX_APPSINKS 8
+
 typedef struct GstPipelineCtx {
   int pipelineId;
   GstElement *pipeline;
-  GstElement *appsink;
+  GstElement *appsinks[GST_PIPELINE_MAX_APPSINKS];
+  int appsinksCount;
   GstElement *appsrc;
 } GstPipelineCtx;
 
-extern void goHandlePipelineBuffer(void *buffer, int bufferLen, int samples, int pipelineId);
+// mapped buffer passed to go, released by gstreamer_buffer_lease_release
+typedef struct GstBufferLease {
+  GstBuffer *buffer;
+  GstMapInfo map;
+} GstBufferLease;
+
+extern void goHandlePipelineBuffer(GstBufferLease *lease, void *data, int dataLen, gint64 duration, gint64 pts, int keyframe, int pipelineId, int sinkId);
+extern void goHandlePipelineError(char *message, int resource, int pipelineId);
+extern void goHandlePipelineBranchEOS(char *sinkName, int pipelineId);
+extern void goHandlePipelineEOS(int pipelineId);
+extern void goHandlePipelineStateChanged(char *state, int pipelineId);
 extern void goPipelineLog(char *level, char *msg, int pipelineId);
 
 GstPipelineCtx *gstreamer_pipeline_create(char *pipelineStr, int pipelineId, GError **error);
-void gstreamer_pipeline_attach_appsink(GstPipelineCtx *ctx, char *sinkName);
+gboolean gstreamer_pipeline_attach_appsink(GstPipelineCtx *ctx, char *sinkName);
 void gstreamer_pipeline_attach_appsrc(GstPipelineCtx *ctx, char *srcName);
-void gstreamer_pipeline_play(GstPipelineCtx *ctx);
-void gstreamer_pipeline_pause(GstPipelineCtx *ctx);
+void gstreamer_buffer_lease_release(GstBufferLease *lease);
+gboolean gstreamer_pipeline_play(GstPipelineCtx *ctx);
+gboolean gstreamer_pipeline_pause(GstPipelineCtx *ctx);
 void gstreamer_pipeline_destory(GstPipelineCtx *ctx);
 void gstreamer_pipeline_push(GstPipelineCtx *ctx, void *buffer, int bufferLen);
 
 gboolean gstreamer_pipeline_set_prop_int(GstPipelineCtx *ctx, char *binName, char *prop, gint value);
+gboolean gstreamer_pipeline_set_prop_str(GstPipelineCtx *ctx, char *binName, char *prop, char *value);
+gchar *gstreamer_pipeline_get_prop_str(GstPipelineCtx *ctx, char *binName, char *prop);
+gchar *gstreamer_pipeline_get_pad_caps(GstPipelineCtx *ctx, char *binName, char *padName);
 gboolean gstreamer_pipeline_set_caps_framerate(GstPipelineCtx *ctx, const gchar* binName, gint numerator, gint denominator);
 gboolean gstreamer_pipeline_set_caps_resolution(GstPipelineCtx *ctx, const gchar* binName, gint width, gint height);
+gboolean gstreamer_pipeline_force_key_unit(GstPipelineCtx *ctx);
+const char *gstreamer_pipeline_get_state(GstPipelineCtx *ctx);
+gboolean gstreamer_pipeline_end_branch(GstPipelineCtx *ctx, char *srcName, char *sinkName);
+gint64 gstreamer_pipeline_query_bytes(GstPipelineCtx *ctx, char *binName);
+gint64 gstreamer_pipeline_query_latency(GstPipelineCtx *ctx);
+gboolean gstreamer_pipeline_rebase_timestamps(GstPipelineCtx *ctx, char *binName);
+gchar *gstreamer_pipeline_dump_dot(GstPipelineCtx *ctx);
+gboolean gstreamer_pipeline_validate(char *pipelineStr, GError **error);
+gboolean gstreamer_pipeline_send_eos(GstPipelineCtx *ctx);
@@ -0,0 +1,100 @@
+package gst
+
+import (
+	"errors"
+	"testing"
+
+	"m1k1o/neko/internal/types"
+)
+
+func TestPipelineErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		pipelineStr string
+		err         error
+	}{
+		{"missing element", "videotestsrc ! nosuchelementfortest ! fakesink", types.ErrPipelineResource},
+		{"syntax error", "videotestsrc ! ! fakesink", types.ErrPipelineParse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePipeline(tt.pipelineStr); !errors.Is(err, tt.err) {
+				t.Fatalf("ValidatePipeline returned %v, expected %v", err, tt.err)
+			}
+
+			pipeline, err := CreatePipeline(tt.pipelineStr)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("CreatePipeline returned %v, expected %v", err, tt.err)
+			}
+
+			if pipeline != nil {
+				t.Fatal("partially created pipeline was returned")
+			}
+		})
+	}
+}
+
+// lease that was already released to gstreamer is not released again
+func TestBufferLeaseExtraRelease(t *testing.T) {
+	l := &bufferLease{}
+
+	l.Release()
+	l.Release()
+
+	if !l.overReleased.Load() {
+		t.Fatal("extra release was not reported")
+	}
+}
+
+// receives raw frames, copied out of the pipeline or leased
+func benchmarkReceiveSamples(b *testing.B, lease bool) {
+	pipeline, err := CreatePipeline("videotestsrc ! video/x-raw,width=1280,height=720 ! appsink name=appsink sync=false")
+	if err != nil {
+		b.Skipf("unable to create pipeline: %v", err)
+	}
+	defer pipeline.Destroy()
+
+	pipeline.SetLeaseBuffers(lease)
+
+	samples := make(chan types.Sample, 8)
+	if err := pipeline.AttachAppsink("appsink", samples); err != nil {
+		b.Fatal(err)
+	}
+
+	if err := pipeline.Play(); err != nil {
+		b.Fatal(err)
+	}
+
+	// first frame includes pipeline startup
+	sample := <-samples
+	b.SetBytes(int64(len(sample.Data)))
+	sample.Release()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		sample := <-samples
+		sample.Release()
+	}
+
+	b.StopTimer()
+
+	if err := pipeline.Pause(); err != nil {
+		b.Fatal(err)
+	}
+
+	for len(samples) > 0 {
+		sample := <-samples
+		sample.Release()
+	}
+}
+
+func BenchmarkReceiveSamplesCopy(b *testing.B) {
+	benchmarkReceiveSamples(b, false)
+}
+
+func BenchmarkReceiveSamplesLease(b *testing.B) {
+	benchmarkReceiveSamples(b, true)
+}
@@ -0,0 +1,58 @@
+package capture
+
+import (
+	"time"
+)
+
+// returns false if pipeline should be running because stream has listeners,
+// but it is missing, it failed or it stopped producing samples for longer than
+// stall timeout. it never waits for pipeline lock, if it is held the result of
+// previous check is returned, so it can be called frequently, e.g. by probes.
+func (manager *StreamSinkManagerCtx) IsHealthy() bool {
+	if !manager.pipelineMu.TryLock() {
+		return manager.healthy.Load()
+	}
+
+	healthy := manager.checkHealth()
+	manager.pipelineMu.Unlock()
+
+	manager.healthy.Store(healthy)
+	return healthy
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) checkHealth() bool {
+	if !manager.Started() {
+		return true
+	}
+
+	// restarting or it could not be created
+	if manager.pipeline == nil {
+		return false
+	}
+
+	if manager.paused {
+		return true
+	}
+
+	if manager.pipelineErrored {
+		return false
+	}
+
+	if manager.stallTimeout <= 0 {
+		return true
+	}
+
+	// samples of previous pipeline do not count
+	since := manager.pipelineCreatedAt
+	if last := manager.LastSampleTime(); last.After(since) {
+		since = last
+	}
+
+	return time.Since(since) < manager.stallTimeout
+}
+
+// returns false if any of the streams is not healthy, see StreamSinkManagerCtx.IsHealthy
+func (manager *CaptureManagerCtx) IsHealthy() bool {
+	return manager.audio.IsHealthy() && manager.video.IsHealthy()
+}
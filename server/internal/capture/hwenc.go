@@ -0,0 +1,130 @@
+package capture
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"m1k1o/neko/internal/capture/gst"
+	"m1k1o/neko/internal/config"
+	"m1k1o/neko/internal/types/codec"
+)
+
+// encoder elements producing given codec sorted by preference, hardware first
+var preferredEncoders = map[string][]string{
+	// video
+	"vp8":     {"vaapivp8enc", "vp8enc"},
+	"vp9":     {"vaapivp9enc", "vp9enc"},
+	"vp9-svc": {"vp9enc"},
+	"av1":     {"av1enc"},
+	"h264":    {"nvh264enc", "vaapih264enc", "openh264enc", "x264enc"},
+	// audio
+	"opus": {"opusenc"},
+	"g722": {"avenc_g722"},
+	"pcmu": {"mulawenc"},
+	"pcma": {"alawenc"},
+	"aac":  {"avenc_aac", "faac", "voaacenc"},
+}
+
+// software encoders are tried when pipeline with hardware ones fails to be created
+const hwEncFallback = config.HwEncNone
+
+var hardwareEncoders = map[string]config.HwEnc{
+	"vaapivp8enc":  config.HwEncVAAPI,
+	"vaapivp9enc":  config.HwEncVAAPI,
+	"vaapih264enc": config.HwEncVAAPI,
+	"nvh264enc":    config.HwEncNVENC,
+}
+
+// probing registry is expensive, elements do not change while running
+var (
+	encodersAvailable   = map[string]bool{}
+	encodersAvailableMu sync.Mutex
+)
+
+func encoderAvailable(name string) bool {
+	encodersAvailableMu.Lock()
+	defer encodersAvailableMu.Unlock()
+
+	available, ok := encodersAvailable[name]
+	if !ok {
+		available = gst.CheckElement(name)
+		encodersAvailable[name] = available
+	}
+
+	return available
+}
+
+// returns name of the most preferred encoder element available for codec
+func PreferredEncoder(rtpCodec codec.RTPCodec) (string, bool) {
+	for _, name := range preferredEncoders[rtpCodec.Name] {
+		if encoderAvailable(name) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// codecs that can be offered to webrtc clients, aac is used only by broadcast
+// and hls, vp9-svc shares mime type with vp9
+var webrtcCodecs = []codec.RTPCodec{
+	codec.VP8(), codec.VP9(), codec.AV1(), codec.H264(),
+	codec.Opus(), codec.G722(), codec.PCMU(), codec.PCMA(),
+}
+
+var (
+	supportedCodecs     []codec.RTPCodec
+	supportedCodecsOnce sync.Once
+)
+
+// returns codecs whose encoder element is available, so that only those are
+// offered in sdp. registry is probed only on the first call.
+func SupportedCodecs() []codec.RTPCodec {
+	supportedCodecsOnce.Do(func() {
+		for _, rtpCodec := range webrtcCodecs {
+			if _, ok := PreferredEncoder(rtpCodec); ok {
+				supportedCodecs = append(supportedCodecs, rtpCodec)
+			}
+		}
+
+		names := make([]string, len(supportedCodecs))
+		for i, rtpCodec := range supportedCodecs {
+			names[i] = rtpCodec.Name
+		}
+
+		log.Info().
+			Str("module", "capture").
+			Strs("codecs", names).
+			Msgf("detected supported codecs")
+	})
+
+	// callers must not modify cached slice
+	return append([]codec.RTPCodec(nil), supportedCodecs...)
+}
+
+// returns hardware encoding available for codec, none if only software encoder is
+func DetectHwEnc(rtpCodec codec.RTPCodec) config.HwEnc {
+	name, ok := PreferredEncoder(rtpCodec)
+	if !ok {
+		return config.HwEncNone
+	}
+
+	return hardwareEncoders[name]
+}
+
+// replaces auto with detected hardware encoding, other values are kept
+func resolveHwEnc(hwenc config.HwEnc, rtpCodec codec.RTPCodec) config.HwEnc {
+	if hwenc != config.HwEncAuto {
+		return hwenc
+	}
+
+	hwenc = DetectHwEnc(rtpCodec)
+	log.Info().
+		Str("module", "capture").
+		Str("codec", rtpCodec.Name).
+		Int("hwenc", int(hwenc)).
+		Msgf("detected video hw encoder")
+
+	return hwenc
+}
@@ -0,0 +1,114 @@
+package capture
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"m1k1o/neko/internal/types"
+)
+
+// number of most recent joins the aggregate is computed from
+const joinLatencyWindow = 100
+
+// measures time from AddListener to the first sample emitted after it, listeners
+// are anonymous, so all waiting ones are resolved by the same sample
+type joinLatency struct {
+	mu       sync.Mutex
+	waiting  []time.Time
+	joins    [joinLatencyWindow]types.ListenerJoinLatency
+	next     int
+	count    int
+	nwaiting atomic.Int32 // so that emit does not lock when nobody waits
+}
+
+func (stats *joinLatency) join(at time.Time) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.waiting = append(stats.waiting, at)
+	stats.nwaiting.Store(int32(len(stats.waiting)))
+}
+
+// resolves waiting joins with sample emitted at now
+func (stats *joinLatency) sample(now time.Time) {
+	if stats.nwaiting.Load() == 0 {
+		return
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	for _, at := range stats.waiting {
+		stats.joins[stats.next] = types.ListenerJoinLatency{
+			JoinedAt: at,
+			Latency:  now.Sub(at),
+		}
+		stats.next = (stats.next + 1) % joinLatencyWindow
+		if stats.count < joinLatencyWindow {
+			stats.count++
+		}
+	}
+
+	stats.waiting = stats.waiting[:0]
+	stats.nwaiting.Store(0)
+}
+
+// drops waiting joins, e.g. when all listeners left before they got a sample
+func (stats *joinLatency) reset() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.waiting = stats.waiting[:0]
+	stats.nwaiting.Store(0)
+}
+
+// drops waiting joins and recorded latencies
+func (stats *joinLatency) clear() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.waiting = stats.waiting[:0]
+	stats.nwaiting.Store(0)
+	stats.next = 0
+	stats.count = 0
+}
+
+func (stats *joinLatency) get() types.StreamJoinLatencyStats {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	result := types.StreamJoinLatencyStats{
+		Joins: make([]types.ListenerJoinLatency, stats.count),
+	}
+
+	if stats.count == 0 {
+		return result
+	}
+
+	// oldest join first
+	start := (stats.next - stats.count + joinLatencyWindow) % joinLatencyWindow
+	latencies := make([]time.Duration, stats.count)
+	var total time.Duration
+	for i := 0; i < stats.count; i++ {
+		join := stats.joins[(start+i)%joinLatencyWindow]
+		result.Joins[i] = join
+		latencies[i] = join.Latency
+		total += join.Latency
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	result.Min = latencies[0]
+	result.Max = latencies[len(latencies)-1]
+	result.Avg = total / time.Duration(len(latencies))
+	result.P95 = latencies[(len(latencies)*95+99)/100-1]
+	return result
+}
+
+// returns time it took recent listeners to get their first sample since
+// AddListener was called, including pipeline startup if they started it
+func (manager *StreamSinkManagerCtx) JoinLatencies() types.StreamJoinLatencyStats {
+	return manager.joinLatency.get()
+}
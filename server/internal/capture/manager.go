@@ -2,6 +2,7 @@ package capture
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -24,7 +25,9 @@ type CaptureManagerCtx struct {
 func New(desktop types.DesktopManager, config *config.Capture) *CaptureManagerCtx {
 	logger := log.With().Str("module", "capture").Logger()
 
-	return &CaptureManagerCtx{
+	hwenc := resolveHwEnc(config.VideoHWEnc, config.VideoCodec)
+
+	manager := &CaptureManagerCtx{
 		logger:  logger,
 		desktop: desktop,
 
@@ -32,19 +35,103 @@ func New(desktop types.DesktopManager, config *config.Capture) *CaptureManagerCt
 		broadcast: broadcastNew(func(url string) (string, error) {
 			return NewBroadcastPipeline(config.AudioDevice, config.Display, config.BroadcastPipeline, url)
 		}, config.BroadcastUrl, config.BroadcastAutostart),
-		audio: streamSinkNew(config.AudioCodec, func() (string, error) {
-			return NewAudioPipeline(config.AudioCodec, config.AudioDevice, config.AudioPipeline, config.AudioBitrate)
-		}, "audio"),
-		video: streamSinkNew(config.VideoCodec, func() (string, error) {
-			// use screen fps as default
-			fps := desktop.GetScreenSize().Rate
-			// if max fps is set, cap it to that value
-			if config.VideoMaxFPS > 0 && config.VideoMaxFPS < fps {
-				fps = config.VideoMaxFPS
+		audio: streamSinkNew(config.AudioCodec, func(params PipelineParams) (string, error) {
+			bitrate := config.AudioBitrate
+			if params.Bitrate > 0 {
+				bitrate = uint(params.Bitrate)
+			}
+			// use changed audio source, if set
+			device := config.AudioDevice
+			if params.AudioSource != "" {
+				device = params.AudioSource
 			}
-			return NewVideoPipeline(config.VideoCodec, config.Display, config.VideoPipeline, fps, config.VideoBitrate, config.VideoHWEnc)
-		}, "video"),
+			return NewAudioPipeline(config.AudioCodec, device, config.AudioPipeline, bitrate, AudioParams{
+				Channels:   config.AudioChannels,
+				SampleRate: config.AudioSampleRate,
+			})
+		}, "audio", config.PipelineRestartAttempts, nil, config.SampleBufferSize, config.SampleStallTimeout, log.Logger),
+		video: streamSinkNew(config.VideoCodec, videoPipelineFn(desktop, config, hwenc), "video", config.PipelineRestartAttempts, videoLayerNames(config.VideoLayers), config.SampleBufferSize, config.SampleStallTimeout, log.Logger),
+	}
+
+	// region capture must fit the display
+	manager.video.displaySizeFn = func() (int, int) {
+		size := desktop.GetScreenSize()
+		if size == nil {
+			return 0, 0
+		}
+		return size.Width, size.Height
+	}
+
+	// recreate video pipeline when display is resized outside of neko
+	manager.video.watchSourceSize()
+
+	// hls segments include audio captured from the same device
+	audioParams := AudioParams{
+		Channels:   config.AudioChannels,
+		SampleRate: config.AudioSampleRate,
 	}
+	manager.video.hlsAudioSrc = fmt.Sprintf(audioSrc, config.AudioDevice, audioParams.caps())
+
+	// device is captured once while both audio stream and hls need it
+	if err := gst.CheckPlugins([]string{"inter"}); err == nil {
+		source := newSharedAudioSource(config.AudioDevice, audioParams)
+		manager.audio.sharedSources = []*sharedSource{source}
+		manager.video.sharedSources = []*sharedSource{source}
+	} else {
+		logger.Warn().Err(err).Msg("audio device can not be shared, hls audio captures it separately")
+	}
+
+	if err := manager.video.SetScreenshotMaxAge(config.ScreenshotMaxAge); err != nil {
+		logger.Warn().Err(err).Msg("unable to set screenshot max age")
+	}
+
+	// changed display must exist
+	manager.video.displayExistsFn = desktop.DisplayExists
+	manager.audio.audioSourceExistsFn = pulseSourceExists
+
+	// hardware encoder may fail to initialize at runtime, e.g. when driver is missing
+	if hwenc != hwEncFallback {
+		manager.video.SetPipelineFallbacks(videoPipelineFn(desktop, config, hwEncFallback))
+	}
+
+	return manager
+}
+
+// returns builder of video pipeline using given hardware encoder
+func videoPipelineFn(desktop types.DesktopManager, config *config.Capture, hwenc config.HwEnc) func(params PipelineParams) (string, error) {
+	return func(params PipelineParams) (string, error) {
+		// use screen fps as default
+		fps := desktop.GetScreenSize().Rate
+		// if max fps is set, cap it to that value
+		if config.VideoMaxFPS > 0 && config.VideoMaxFPS < fps {
+			fps = config.VideoMaxFPS
+		}
+		// use changed framerate, if set
+		if params.Framerate > 0 {
+			fps = params.Framerate
+		}
+		// use changed display, if set
+		display := config.Display
+		if params.Display != "" {
+			display = params.Display
+		}
+		if len(config.VideoLayers) > 0 {
+			return NewVideoLayersPipeline(config.VideoCodec, display, config.VideoPipeline, fps, config.VideoLayers, hwenc)
+		}
+		bitrate := config.VideoBitrate
+		if params.Bitrate > 0 {
+			bitrate = uint(params.Bitrate)
+		}
+		return NewVideoPipeline(config.VideoCodec, display, config.VideoPipeline, fps, bitrate, hwenc)
+	}
+}
+
+func videoLayerNames(layers []config.VideoLayer) []string {
+	names := make([]string, len(layers))
+	for i, layer := range layers {
+		names[i] = layer.Name
+	}
+	return names
 }
 
 func (manager *CaptureManagerCtx) Start() {
@@ -64,11 +151,10 @@ func (manager *CaptureManagerCtx) Start() {
 			}
 
 			if before {
-				// before screen size change, we need to destroy all pipelines
+				// before screen size change, we need to destroy all pipelines,
+				// including paused ones
 
-				if manager.video.Started() {
-					manager.video.destroyPipeline()
-				}
+				manager.video.destroyPipeline()
 
 				if manager.broadcast.Started() {
 					manager.broadcast.destroyPipeline()
@@ -76,11 +162,8 @@ func (manager *CaptureManagerCtx) Start() {
 			} else {
 				// after screen size change, we need to recreate all pipelines
 
-				if manager.video.Started() {
-					err := manager.video.createPipeline()
-					if err != nil && !errors.Is(err, types.ErrCapturePipelineAlreadyExists) {
-						manager.logger.Panic().Err(err).Msg("unable to recreate video pipeline")
-					}
+				if err := manager.video.restorePipeline(); err != nil {
+					manager.logger.Panic().Err(err).Msg("unable to recreate video pipeline")
 				}
 
 				if manager.broadcast.Started() {
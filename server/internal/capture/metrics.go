@@ -0,0 +1,112 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+)
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func(manager *StreamSinkManagerCtx) float64
+}
+
+var streamSinkMetrics = []metric{
+	{
+		name: "neko_capture_samples_total",
+		help: "Total number of samples emitted by the stream pipeline.",
+		kind: "counter",
+		value: func(manager *StreamSinkManagerCtx) float64 {
+			return float64(manager.emittedSamples.Load())
+		},
+	},
+	{
+		name: "neko_capture_bytes_total",
+		help: "Total number of bytes emitted by the stream pipeline.",
+		kind: "counter",
+		value: func(manager *StreamSinkManagerCtx) float64 {
+			return float64(manager.emittedBytes.Load())
+		},
+	},
+	{
+		name: "neko_capture_keyframes_total",
+		help: "Total number of samples emitted by the stream pipeline that are keyframes.",
+		kind: "counter",
+		value: func(manager *StreamSinkManagerCtx) float64 {
+			return float64(manager.keyframes.Load())
+		},
+	},
+	{
+		name: "neko_capture_dropped_samples_total",
+		help: "Total number of samples dropped because stream or its subscribers were not keeping up.",
+		kind: "counter",
+		value: func(manager *StreamSinkManagerCtx) float64 {
+			return float64(manager.DroppedSamples())
+		},
+	},
+	{
+		name: "neko_capture_listeners",
+		help: "Current number of stream listeners.",
+		kind: "gauge",
+		value: func(manager *StreamSinkManagerCtx) float64 {
+			return float64(manager.ListenersCount())
+		},
+	},
+	{
+		name: "neko_capture_join_latency_seconds",
+		help: "Average time recent listeners waited for their first sample.",
+		kind: "gauge",
+		value: func(manager *StreamSinkManagerCtx) float64 {
+			return manager.JoinLatencies().Avg.Seconds()
+		},
+	},
+	{
+		name: "neko_capture_pipeline_uptime_seconds",
+		help: "How long the current stream pipeline is running.",
+		kind: "gauge",
+		value: func(manager *StreamSinkManagerCtx) float64 {
+			return manager.Uptime().Seconds()
+		},
+	},
+	{
+		name: "neko_capture_pipeline_restarts_total",
+		help: "Total number of restarts of failed stream pipeline.",
+		kind: "counter",
+		value: func(manager *StreamSinkManagerCtx) float64 {
+			return float64(manager.RestartCount())
+		},
+	},
+	{
+		name: "neko_capture_pipeline_up",
+		help: "Whether the stream pipeline is playing.",
+		kind: "gauge",
+		value: func(manager *StreamSinkManagerCtx) float64 {
+			if manager.running() {
+				return 1
+			}
+			return 0
+		},
+	},
+}
+
+// writes metrics of all stream sinks in prometheus text format, labels match
+// the ones used in logs
+func (manager *CaptureManagerCtx) WriteMetrics(w io.Writer) error {
+	sinks := []*StreamSinkManagerCtx{manager.audio, manager.video}
+
+	for _, m := range streamSinkMetrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+
+		for _, sink := range sinks {
+			_, err := fmt.Fprintf(w, "%s{module=\"capture\",submodule=\"stream-sink\",video_id=%q} %g\n", m.name, sink.id, m.value(sink))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -30,13 +30,67 @@ import (
 */
 
 const (
-	videoSrc = "ximagesrc display-name=%s show-pointer=true use-damage=false ! video/x-raw,framerate=%d/1 ! videoconvert ! queue ! "
-	audioSrc = "pulsesrc device=%s ! audio/x-raw,channels=2 ! audioconvert ! "
+	videoSrc = "ximagesrc name=source display-name=%s show-pointer=true use-damage=false ! capsfilter name=framerate caps=video/x-raw,framerate=%d/1 ! videoconvert ! queue ! "
+	audioSrc = "pulsesrc device=%s ! audio/x-raw,%s ! audioconvert ! "
 )
 
+// audio format captured from the device, zero values mean codec default
+type AudioParams struct {
+	Channels   int
+	SampleRate int
+}
+
+// sample rates opus can encode, its rtp clock rate is always 48kHz
+var opusSampleRates = map[int]bool{8000: true, 12000: true, 16000: true, 24000: true, 48000: true}
+
+func (params AudioParams) validate(rtpCodec codec.RTPCodec) error {
+	if params.Channels < 0 || params.Channels > 2 {
+		return fmt.Errorf("invalid audio channels %d, must be 1 or 2", params.Channels)
+	}
+
+	if params.SampleRate == 0 {
+		return nil
+	}
+
+	clockRate := int(rtpCodec.Capability.ClockRate)
+
+	switch rtpCodec.Name {
+	case codec.Opus().Name:
+		if !opusSampleRates[params.SampleRate] || params.SampleRate > clockRate {
+			return fmt.Errorf("invalid sample rate %d for opus, must be 8000, 12000, 16000, 24000 or 48000", params.SampleRate)
+		}
+	case codec.G722().Name:
+		// g722 samples at 16kHz, but its rtp clock rate is 8kHz for historic reasons
+		if params.SampleRate != 2*clockRate {
+			return fmt.Errorf("invalid sample rate %d for g722, must be %d", params.SampleRate, 2*clockRate)
+		}
+	default:
+		if params.SampleRate != clockRate {
+			return fmt.Errorf("invalid sample rate %d for %s, must be %d", params.SampleRate, rtpCodec.Name, clockRate)
+		}
+	}
+
+	return nil
+}
+
+// returns raw audio caps of the source, stereo if channels are not set
+func (params AudioParams) caps() string {
+	channels := params.Channels
+	if channels == 0 {
+		channels = 2
+	}
+
+	caps := fmt.Sprintf("channels=%d", channels)
+	if params.SampleRate > 0 {
+		caps += fmt.Sprintf(",rate=%d", params.SampleRate)
+	}
+
+	return caps
+}
+
 func NewBroadcastPipeline(device string, display string, pipelineSrc string, url string) (string, error) {
 	video := fmt.Sprintf(videoSrc, display, 25)
-	audio := fmt.Sprintf(audioSrc, device)
+	audio := fmt.Sprintf(audioSrc, device, AudioParams{}.caps())
 
 	var pipelineStr string
 	if pipelineSrc != "" {
@@ -47,7 +101,12 @@ func NewBroadcastPipeline(device string, display string, pipelineSrc string, url
 		// replace display
 		pipelineStr = strings.Replace(pipelineStr, "{display}", display, -1)
 	} else {
-		pipelineStr = fmt.Sprintf("flvmux name=mux ! rtmpsink location='%s live=1' %s audio/x-raw,channels=2 ! audioconvert ! voaacenc ! mux. %s x264enc bframes=0 key-int-max=60 byte-stream=true tune=zerolatency speed-preset=veryfast ! mux.", url, audio, video)
+		aacStr, err := NewAACPipeline("", 0)
+		if err != nil {
+			return "", err
+		}
+
+		pipelineStr = fmt.Sprintf("flvmux name=mux ! rtmpsink location='%s live=1' %s audio/x-raw,channels=2 ! %s ! mux. %s x264enc bframes=0 key-int-max=60 byte-stream=true tune=zerolatency speed-preset=veryfast ! mux.", url, audio, aacStr, video)
 	}
 
 	return pipelineStr, nil
@@ -76,7 +135,7 @@ func NewVideoPipeline(rtpCodec codec.RTPCodec, display string, pipelineSrc strin
 			// vp8 encode is missing from gstreamer.freedesktop.org/documentation
 			// note that it was removed from some recent intel CPUs: https://trac.ffmpeg.org/wiki/Hardware/QuickSync
 			// https://gstreamer.freedesktop.org/data/doc/gstreamer/head/gstreamer-vaapi-plugins/html/gstreamer-vaapi-plugins-vaapivp8enc.html
-			pipelineStr = fmt.Sprintf(videoSrc+"video/x-raw,format=NV12 ! vaapivp8enc rate-control=vbr bitrate=%d keyframe-period=180"+pipelineStr, display, fps, bitrate)
+			pipelineStr = fmt.Sprintf(videoSrc+"video/x-raw,format=NV12 ! vaapivp8enc name=encoder rate-control=vbr bitrate=%d keyframe-period=180"+pipelineStr, display, fps, bitrate)
 		} else {
 			// https://gstreamer.freedesktop.org/documentation/vpx/vp8enc.html?gi-language=c
 			// gstreamer1.0-plugins-good
@@ -88,6 +147,7 @@ func NewVideoPipeline(rtpCodec codec.RTPCodec, display string, pipelineSrc strin
 			pipelineStr = strings.Join([]string{
 				fmt.Sprintf(videoSrc, display, fps),
 				"vp8enc",
+				"name=encoder",
 				fmt.Sprintf("target-bitrate=%d", bitrate*650),
 				"cpu-used=4",
 				"end-usage=cbr",
@@ -111,7 +171,33 @@ func NewVideoPipeline(rtpCodec codec.RTPCodec, display string, pipelineSrc strin
 			return "", err
 		}
 
-		pipelineStr = fmt.Sprintf(videoSrc+"vp9enc target-bitrate=%d cpu-used=-5 threads=4 deadline=1 keyframe-max-dist=30 auto-alt-ref=true"+pipelineStr, display, fps, bitrate*1000)
+		pipelineStr = fmt.Sprintf(videoSrc+"vp9enc name=encoder target-bitrate=%d cpu-used=-5 threads=4 deadline=1 keyframe-max-dist=30 auto-alt-ref=true"+pipelineStr, display, fps, bitrate*1000)
+	case codec.VP9SVC().Name:
+		// https://gstreamer.freedesktop.org/documentation/vpx/vp9enc.html?gi-language=c
+		// gstreamer1.0-plugins-good
+		// vp9enc with temporal layers, every other frame is in the base layer
+		if err := gst.CheckPlugins([]string{"ximagesrc", "vpx"}); err != nil {
+			return "", err
+		}
+
+		pipelineStr = strings.Join([]string{
+			fmt.Sprintf(videoSrc, display, fps),
+			"vp9enc",
+			"name=encoder",
+			fmt.Sprintf("target-bitrate=%d", bitrate*1000),
+			"cpu-used=-5",
+			"threads=4",
+			"deadline=1",
+			"keyframe-max-dist=30",
+			"error-resilient=default",
+			fmt.Sprintf("temporal-scalability-number-layers=%d", len(vp9SVCTemporalLayers)),
+			fmt.Sprintf("temporal-scalability-periodicity=%d", len(vp9SVCTemporalLayers)),
+			"temporal-scalability-layer-id=\"<0,1>\"",
+			"temporal-scalability-rate-decimator=\"<2,1>\"",
+			// cumulative bitrates of layers, base layer gets 60%
+			fmt.Sprintf("temporal-scalability-target-bitrate=\"<%d,%d>\"", bitrate*600, bitrate*1000),
+			pipelineStr,
+		}, " ")
 	case codec.AV1().Name:
 		// https://gstreamer.freedesktop.org/documentation/aom/av1enc.html?gi-language=c
 		// gstreamer1.0-plugins-bad
@@ -124,6 +210,7 @@ func NewVideoPipeline(rtpCodec codec.RTPCodec, display string, pipelineSrc strin
 		pipelineStr = strings.Join([]string{
 			fmt.Sprintf(videoSrc, display, fps),
 			"av1enc",
+			"name=encoder",
 			fmt.Sprintf("target-bitrate=%d", bitrate*650),
 			"cpu-used=4",
 			"end-usage=cbr",
@@ -149,7 +236,7 @@ func NewVideoPipeline(rtpCodec codec.RTPCodec, display string, pipelineSrc strin
 				return "", err
 			}
 
-			pipelineStr = fmt.Sprintf(videoSrc+"video/x-raw,format=NV12 ! vaapih264enc rate-control=vbr bitrate=%d keyframe-period=180 quality-level=7 ! video/x-h264,stream-format=byte-stream,profile=constrained-baseline"+pipelineStr, display, fps, bitrate)
+			pipelineStr = fmt.Sprintf(videoSrc+"video/x-raw,format=NV12 ! vaapih264enc name=encoder rate-control=vbr bitrate=%d keyframe-period=180 quality-level=7 ! video/x-h264,stream-format=byte-stream,profile=constrained-baseline"+pipelineStr, display, fps, bitrate)
 		} else if hwenc == config.HwEncNVENC {
 			if err := gst.CheckPlugins([]string{"nvcodec"}); err != nil {
 				return "", err
@@ -161,7 +248,7 @@ func NewVideoPipeline(rtpCodec codec.RTPCodec, display string, pipelineSrc strin
 			// gstreamer1.0-plugins-bad
 			// openh264enc multi-thread=4 complexity=high bitrate=3072000 max-bitrate=4096000
 			if err := gst.CheckPlugins([]string{"openh264"}); err == nil {
-				pipelineStr = fmt.Sprintf(videoSrc+"openh264enc multi-thread=4 complexity=high bitrate=%d max-bitrate=%d ! video/x-h264,stream-format=byte-stream,profile=constrained-baseline"+pipelineStr, display, fps, bitrate*1000, (bitrate+1024)*1000)
+				pipelineStr = fmt.Sprintf(videoSrc+"openh264enc name=encoder multi-thread=4 complexity=high bitrate=%d max-bitrate=%d ! video/x-h264,stream-format=byte-stream,profile=constrained-baseline"+pipelineStr, display, fps, bitrate*1000, (bitrate+1024)*1000)
 				break
 			}
 
@@ -172,7 +259,7 @@ func NewVideoPipeline(rtpCodec codec.RTPCodec, display string, pipelineSrc strin
 				return "", err
 			}
 
-			pipelineStr = fmt.Sprintf(videoSrc+"video/x-raw,format=NV12 ! x264enc threads=4 bitrate=%d key-int-max=60 vbv-buf-capacity=%d byte-stream=true tune=zerolatency speed-preset=veryfast ! video/x-h264,stream-format=byte-stream,profile=constrained-baseline"+pipelineStr, display, fps, bitrate, vbvbuf)
+			pipelineStr = fmt.Sprintf(videoSrc+"video/x-raw,format=NV12 ! x264enc name=encoder threads=4 bitrate=%d key-int-max=60 vbv-buf-capacity=%d byte-stream=true tune=zerolatency speed-preset=veryfast ! video/x-h264,stream-format=byte-stream,profile=constrained-baseline"+pipelineStr, display, fps, bitrate, vbvbuf)
 		}
 	default:
 		return "", fmt.Errorf("unknown codec %s", rtpCodec.Name)
@@ -181,7 +268,39 @@ func NewVideoPipeline(rtpCodec codec.RTPCodec, display string, pipelineSrc strin
 	return pipelineStr, nil
 }
 
-func NewAudioPipeline(rtpCodec codec.RTPCodec, device string, pipelineSrc string, bitrate uint) (string, error) {
+// builds pipeline with one encoder branch per layer, custom pipeline must contain
+// appsinks named appsinkvideo_<layer> for all layers
+func NewVideoLayersPipeline(rtpCodec codec.RTPCodec, display string, pipelineSrc string, fps int16, layers []config.VideoLayer, hwenc config.HwEnc) (string, error) {
+	// if using custom pipeline
+	if pipelineSrc != "" {
+		return fmt.Sprintf(pipelineSrc, display), nil
+	}
+
+	// use default fps if not set
+	if fps == 0 {
+		fps = 25
+	}
+
+	src := fmt.Sprintf(videoSrc, display, fps)
+	branches := []string{src + "tee name=layers"}
+
+	for _, layer := range layers {
+		pipelineStr, err := NewVideoPipeline(rtpCodec, display, "", fps, layer.Bitrate, hwenc)
+		if err != nil {
+			return "", err
+		}
+
+		// reuse encoder part of the single layer pipeline, element names must be unique
+		branch := strings.TrimPrefix(pipelineStr, src)
+		branch = strings.Replace(branch, "name="+encoderName, "name="+encoderName+"_"+layer.Name, 1)
+		branch = strings.Replace(branch, "name=appsinkvideo", "name=appsinkvideo_"+layer.Name, 1)
+		branches = append(branches, "layers. ! queue ! "+strings.TrimSpace(branch))
+	}
+
+	return strings.Join(branches, " "), nil
+}
+
+func NewAudioPipeline(rtpCodec codec.RTPCodec, device string, pipelineSrc string, bitrate uint, params AudioParams) (string, error) {
 	pipelineStr := " ! appsink name=appsinkaudio"
 
 	// if using custom pipeline
@@ -190,6 +309,12 @@ func NewAudioPipeline(rtpCodec codec.RTPCodec, device string, pipelineSrc string
 		return pipelineStr, nil
 	}
 
+	if err := params.validate(rtpCodec); err != nil {
+		return "", err
+	}
+
+	src := fmt.Sprintf(audioSrc, device, params.caps())
+
 	switch rtpCodec.Name {
 	case codec.Opus().Name:
 		// https://gstreamer.freedesktop.org/documentation/opus/opusenc.html
@@ -199,7 +324,7 @@ func NewAudioPipeline(rtpCodec codec.RTPCodec, device string, pipelineSrc string
 			return "", err
 		}
 
-		pipelineStr = fmt.Sprintf(audioSrc+"opusenc inband-fec=true bitrate=%d"+pipelineStr, device, bitrate*1000)
+		pipelineStr = src + fmt.Sprintf("opusenc name=encoder inband-fec=true bitrate=%d", bitrate*1000) + pipelineStr
 	case codec.G722().Name:
 		// https://gstreamer.freedesktop.org/documentation/libav/avenc_g722.html?gi-language=c
 		// gstreamer1.0-libav
@@ -208,7 +333,7 @@ func NewAudioPipeline(rtpCodec codec.RTPCodec, device string, pipelineSrc string
 			return "", err
 		}
 
-		pipelineStr = fmt.Sprintf(audioSrc+"avenc_g722 bitrate=%d"+pipelineStr, device, bitrate*1000)
+		pipelineStr = src + fmt.Sprintf("avenc_g722 name=encoder bitrate=%d", bitrate*1000) + pipelineStr
 	case codec.PCMU().Name:
 		// https://gstreamer.freedesktop.org/documentation/mulaw/mulawenc.html?gi-language=c
 		// gstreamer1.0-plugins-good
@@ -217,7 +342,7 @@ func NewAudioPipeline(rtpCodec codec.RTPCodec, device string, pipelineSrc string
 			return "", err
 		}
 
-		pipelineStr = fmt.Sprintf(audioSrc+"audio/x-raw, rate=8000 ! mulawenc"+pipelineStr, device)
+		pipelineStr = src + "audio/x-raw, rate=8000 ! mulawenc" + pipelineStr
 	case codec.PCMA().Name:
 		// https://gstreamer.freedesktop.org/documentation/alaw/alawenc.html?gi-language=c
 		// gstreamer1.0-plugins-good
@@ -226,10 +351,230 @@ func NewAudioPipeline(rtpCodec codec.RTPCodec, device string, pipelineSrc string
 			return "", err
 		}
 
-		pipelineStr = fmt.Sprintf(audioSrc+"audio/x-raw, rate=8000 ! alawenc"+pipelineStr, device)
+		pipelineStr = src + "audio/x-raw, rate=8000 ! alawenc" + pipelineStr
+	case codec.AAC().Name:
+		// avenc_aac, faac or voaacenc, whichever is available
+		if err := gst.CheckPlugins([]string{"pulseaudio"}); err != nil {
+			return "", err
+		}
+
+		aacStr, err := NewAACPipeline("encoder", bitrate)
+		if err != nil {
+			return "", err
+		}
+
+		pipelineStr = src + aacStr + pipelineStr
 	default:
 		return "", fmt.Errorf("unknown codec %s", rtpCodec.Name)
 	}
 
 	return pipelineStr, nil
 }
+
+// used by default pipelines when params do not set them
+const (
+	defaultFramerate    = 25
+	defaultVideoBitrate = 3072 // kbit/s
+	defaultAudioBitrate = 128  // kbit/s
+)
+
+// returns known-good pipeline for VP8, VP9, H264 or Opus using software encoders,
+// source is X display for video and pulseaudio device for audio. unset params
+// use defaults, resolution if set scales the captured video.
+func DefaultPipeline(rtpCodec codec.RTPCodec, source string, params PipelineParams) (string, error) {
+	switch rtpCodec.Name {
+	case codec.VP8().Name, codec.VP9().Name, codec.H264().Name:
+		fps := params.Framerate
+		if fps <= 0 {
+			fps = defaultFramerate
+		}
+
+		bitrate := params.Bitrate
+		if bitrate <= 0 {
+			bitrate = defaultVideoBitrate
+		}
+
+		if params.Display != "" {
+			source = params.Display
+		}
+
+		pipelineStr, err := NewVideoPipeline(rtpCodec, source, "", fps, uint(bitrate), config.HwEncNone)
+		if err != nil {
+			return "", err
+		}
+
+		if params.Width > 0 && params.Height > 0 {
+			return setPipelineResolution(pipelineStr, params.Width, params.Height)
+		}
+
+		return pipelineStr, nil
+	case codec.Opus().Name:
+		bitrate := params.Bitrate
+		if bitrate <= 0 {
+			bitrate = defaultAudioBitrate
+		}
+
+		if params.AudioSource != "" {
+			source = params.AudioSource
+		}
+
+		return NewAudioPipeline(rtpCodec, source, "", uint(bitrate), AudioParams{})
+	default:
+		return "", fmt.Errorf("no default pipeline for codec %s", rtpCodec.Name)
+	}
+}
+
+// returns pipelineFn building default pipeline for given codec and source
+func DefaultPipelineFn(rtpCodec codec.RTPCodec, source string) func(params PipelineParams) (string, error) {
+	return func(params PipelineParams) (string, error) {
+		return DefaultPipeline(rtpCodec, source, params)
+	}
+}
+
+// returns the same pipelines as DefaultPipeline, but as element graph
+func DefaultPipelineGraph(rtpCodec codec.RTPCodec, source string, params PipelineParams) (*PipelineGraph, error) {
+	switch rtpCodec.Name {
+	case codec.VP8().Name, codec.VP9().Name, codec.H264().Name:
+		fps := params.Framerate
+		if fps <= 0 {
+			fps = defaultFramerate
+		}
+
+		bitrate := params.Bitrate
+		if bitrate <= 0 {
+			bitrate = defaultVideoBitrate
+		}
+
+		if params.Display != "" {
+			source = params.Display
+		}
+
+		if err := gst.CheckPlugins([]string{"ximagesrc"}); err != nil {
+			return nil, err
+		}
+
+		graph := NewPipelineGraph(
+			NewGraphElement("ximagesrc", sourceName, map[string]string{
+				"display-name": source,
+				"show-pointer": "true",
+				"use-damage":   "false",
+			}),
+			NewGraphCaps(framerateCapsName, fmt.Sprintf("video/x-raw,framerate=%d/1", fps)),
+			NewGraphElement("videoconvert", "", nil),
+			NewGraphElement("queue", "", nil),
+		)
+
+		var encoder *GraphElement
+		switch rtpCodec.Name {
+		case codec.VP8().Name:
+			if err := gst.CheckPlugins([]string{"vpx"}); err != nil {
+				return nil, err
+			}
+
+			encoder = NewGraphElement("vp8enc", encoderName, map[string]string{
+				"cpu-used":            "4",
+				"end-usage":           "cbr",
+				"threads":             "4",
+				"deadline":            "1",
+				"undershoot":          "95",
+				"buffer-size":         fmt.Sprint(bitrate * 4),
+				"buffer-initial-size": fmt.Sprint(bitrate * 2),
+				"buffer-optimal-size": fmt.Sprint(bitrate * 3),
+				"keyframe-max-dist":   "25",
+				"min-quantizer":       "4",
+				"max-quantizer":       "20",
+			})
+			graph.Elements = append(graph.Elements, encoder)
+		case codec.VP9().Name:
+			if err := gst.CheckPlugins([]string{"vpx"}); err != nil {
+				return nil, err
+			}
+
+			encoder = NewGraphElement("vp9enc", encoderName, map[string]string{
+				"cpu-used":          "-5",
+				"threads":           "4",
+				"deadline":          "1",
+				"keyframe-max-dist": "30",
+				"auto-alt-ref":      "true",
+			})
+			graph.Elements = append(graph.Elements, encoder)
+		case codec.H264().Name:
+			if err := gst.CheckPlugins([]string{"openh264"}); err == nil {
+				encoder = NewGraphElement("openh264enc", encoderName, map[string]string{
+					"multi-thread": "4",
+					"complexity":   "high",
+					"max-bitrate":  fmt.Sprint((bitrate + 1024) * 1000),
+				})
+				graph.Elements = append(graph.Elements, encoder)
+			} else {
+				if err := gst.CheckPlugins([]string{"x264"}); err != nil {
+					return nil, err
+				}
+
+				vbvbuf := 1000
+				if bitrate > 1000 {
+					vbvbuf = bitrate
+				}
+
+				encoder = NewGraphElement("x264enc", encoderName, map[string]string{
+					"threads":          "4",
+					"key-int-max":      "60",
+					"vbv-buf-capacity": fmt.Sprint(vbvbuf),
+					"byte-stream":      "true",
+					"tune":             "zerolatency",
+					"speed-preset":     "veryfast",
+				})
+				graph.Elements = append(graph.Elements, NewGraphCaps("", "video/x-raw,format=NV12"), encoder)
+			}
+
+			graph.Elements = append(graph.Elements, NewGraphCaps("", "video/x-h264,stream-format=byte-stream,profile=constrained-baseline"))
+		}
+
+		graph.Elements = append(graph.Elements, NewGraphElement("appsink", "appsinkvideo", nil))
+
+		if err := graph.SetBitrate(bitrate); err != nil {
+			return nil, err
+		}
+
+		if params.Width > 0 && params.Height > 0 {
+			if err := graph.SetResolution(params.Width, params.Height); err != nil {
+				return nil, err
+			}
+		}
+
+		return graph, nil
+	case codec.Opus().Name:
+		bitrate := params.Bitrate
+		if bitrate <= 0 {
+			bitrate = defaultAudioBitrate
+		}
+
+		if err := gst.CheckPlugins([]string{"pulseaudio", "opus"}); err != nil {
+			return nil, err
+		}
+
+		if params.AudioSource != "" {
+			source = params.AudioSource
+		}
+
+		return NewPipelineGraph(
+			NewGraphElement("pulsesrc", "", map[string]string{"device": source}),
+			NewGraphCaps("", "audio/x-raw,"+AudioParams{}.caps()),
+			NewGraphElement("audioconvert", "", nil),
+			NewGraphElement("opusenc", encoderName, map[string]string{
+				"inband-fec": "true",
+				"bitrate":    fmt.Sprint(bitrate * 1000),
+			}),
+			NewGraphElement("appsink", "appsinkaudio", nil),
+		), nil
+	default:
+		return nil, fmt.Errorf("no default pipeline for codec %s", rtpCodec.Name)
+	}
+}
+
+// returns pipelineGraphFn building default pipeline graph for given codec and source
+func DefaultPipelineGraphFn(rtpCodec codec.RTPCodec, source string) func(params PipelineParams) (*PipelineGraph, error) {
+	return func(params PipelineParams) (*PipelineGraph, error) {
+		return DefaultPipelineGraph(rtpCodec, source, params)
+	}
+}
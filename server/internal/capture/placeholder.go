@@ -0,0 +1,145 @@
+package capture
+
+import (
+	"fmt"
+	"strings"
+
+	"m1k1o/neko/internal/types"
+)
+
+// name of the element replacing capture source in placeholder pipeline
+const placeholderSrcName = "placeholder"
+
+// replaces ximagesrc of the main chain of pipeline string by black test
+// pattern, so that placeholder frames are encoded the same way as captured ones.
+// zero width and height keep default size of the pattern.
+func placeholderPipelineStr(pipelineStr string, width, height int) (string, error) {
+	elements := strings.Split(pipelineStr, "!")
+
+	fields := strings.Fields(elements[0])
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: pipeline is empty", types.ErrPipelineParse)
+	}
+
+	if fields[0] != "ximagesrc" {
+		return "", fmt.Errorf("source element %s does not support placeholder", fields[0])
+	}
+
+	src := fmt.Sprintf("videotestsrc name=%s is-live=true pattern=black ", placeholderSrcName)
+	if width > 0 && height > 0 {
+		src += fmt.Sprintf("! video/x-raw,width=%d,height=%d ", width, height)
+	}
+
+	elements[0] = src
+	return strings.Join(elements, "!"), nil
+}
+
+// when enabled, black frames are sent to subscribers after pipeline is created
+// until it emits its first sample, so that new viewers always have something
+// to decode. applied when pipeline is created next time.
+func (manager *StreamSinkManagerCtx) SetPlaceholder(enabled bool) error {
+	if rtpCodec := manager.Codec(); !rtpCodec.IsVideo() {
+		return fmt.Errorf("unable to set placeholder of %s stream", rtpCodec.Name)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.placeholder = enabled
+	if !enabled {
+		manager.stopPlaceholder()
+	}
+
+	return nil
+}
+
+func (manager *StreamSinkManagerCtx) Placeholder() bool {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.placeholder
+}
+
+// starts placeholder pipeline built from main chain of the pipeline string,
+// its samples are forwarded to the same emit loops as samples of the pipeline.
+// failure is not fatal, stream only starts without placeholder. must be called
+// with pipelineMu held
+func (manager *StreamSinkManagerCtx) startPlaceholder(pipelineStr string) {
+	manager.stopPlaceholder()
+
+	// pattern has size of captured frames, unless main chain scales them anyway
+	width, height := manager.sourceWidth, manager.sourceHeight
+	if manager.region != nil {
+		width, height = manager.region.width, manager.region.height
+	}
+	if manager.width > 0 && manager.height > 0 {
+		width, height = 0, 0
+	}
+
+	placeholderStr, err := placeholderPipelineStr(pipelineStr, width, height)
+	if err != nil {
+		manager.logger().Warn().Err(err).Msg("unable to create placeholder pipeline")
+		return
+	}
+
+	pipeline, err := manager.createPipelineFn(placeholderStr)
+	if err != nil {
+		manager.logger().Warn().Err(err).Msg("unable to create placeholder pipeline")
+		return
+	}
+
+	pipeline.SetLatestOnly(true)
+
+	for _, layer := range manager.layers {
+		samples := make(chan types.Sample, defaultSampleBufferSize)
+		if err := pipeline.AttachAppsink(manager.appsinkName(layer), samples); err != nil {
+			pipeline.Destroy()
+			manager.logger().Warn().Err(err).Msg("unable to create placeholder pipeline")
+			return
+		}
+
+		go manager.forwardPlaceholder(pipeline, manager.sampleChannels[layer], samples)
+	}
+
+	if err := pipeline.Play(); err != nil {
+		pipeline.Destroy()
+		manager.logger().Warn().Err(err).Msg("unable to start placeholder pipeline")
+		return
+	}
+
+	manager.placeholderPipeline = pipeline
+	manager.placeholderActive.Store(true)
+	manager.logger().Info().Str("src", placeholderStr).Msg("placeholder pipeline started")
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) stopPlaceholder() {
+	manager.placeholderActive.Store(false)
+
+	if manager.placeholderPipeline == nil {
+		return
+	}
+
+	manager.placeholderPipeline.Destroy()
+	manager.placeholderPipeline = nil
+	manager.logger().Info().Msg("placeholder pipeline stopped")
+}
+
+// marks placeholder samples and passes them to emit loop without blocking,
+// until placeholder pipeline is destroyed
+func (manager *StreamSinkManagerCtx) forwardPlaceholder(pipeline types.Pipeline, emit chan<- types.Sample, samples <-chan types.Sample) {
+	for {
+		select {
+		case sample := <-samples:
+			sample.Placeholder = true
+
+			select {
+			case emit <- sample:
+			default:
+				sample.Release()
+			}
+		case <-pipeline.Destroyed():
+			return
+		}
+	}
+}
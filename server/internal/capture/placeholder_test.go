@@ -0,0 +1,64 @@
+package capture
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"m1k1o/neko/internal/types"
+)
+
+// placeholder is stopped by one goroutine, no matter how many samples of the
+// pipeline are emitted before it gets the lock
+func TestPlaceholderStoppedOnce(t *testing.T) {
+	pipelines := &fakePipelines{}
+	manager := newTestManager(t, pipelines)
+
+	if err := manager.SetPlaceholder(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.RemoveListener()
+
+	pipeline, placeholder := currentPipeline(manager), pipelines.last()
+	if !strings.Contains(placeholder.Src(), "name="+placeholderSrcName) {
+		t.Fatalf("placeholder pipeline was not started: %s", placeholder.Src())
+	}
+
+	// stop goroutines wait for the lock while samples are emitted
+	manager.pipelineMu.Lock()
+	goroutines := runtime.NumGoroutine()
+
+	const samples = 100
+	for i := 0; i < samples; {
+		if pipeline.push("appsinkvideo", types.Sample{PTS: -1}) {
+			i++
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for manager.emittedSamples.Load() < samples {
+		time.Sleep(time.Millisecond)
+	}
+
+	started := runtime.NumGoroutine() - goroutines
+	manager.pipelineMu.Unlock()
+
+	if started > 1 {
+		t.Fatalf("%d goroutines were started to stop placeholder", started)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for placeholder.State() != "NULL" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, _, destroys := placeholder.calls(); destroys != 1 {
+		t.Fatalf("placeholder pipeline was destroyed %d times", destroys)
+	}
+}
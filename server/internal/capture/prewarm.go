@@ -0,0 +1,110 @@
+package capture
+
+import (
+	"time"
+)
+
+// builds pipeline ahead of the first listener and keeps it paused, listeners
+// count is not changed. first listener then only resumes the pipeline. while
+// prewarmed, last listener leaving pauses the pipeline instead of destroying
+// it, until Cooldown is called or timeout expires, zero timeout never expires.
+// calling it again renews the timeout.
+func (manager *StreamSinkManagerCtx) Prewarm(timeout time.Duration) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		if err := manager.buildPipeline(); err != nil {
+			return err
+		}
+	}
+
+	if manager.prewarmTimer != nil {
+		manager.prewarmTimer.Stop()
+		manager.prewarmTimer = nil
+	}
+
+	manager.prewarmed = true
+	if timeout > 0 {
+		// timer is compared in cooldown, so that expired timer does not end renewed prewarm,
+		// it cannot fire before it is assigned because cooldown takes the same locks
+		var timer *time.Timer
+		timer = time.AfterFunc(timeout, func() {
+			manager.cooldown(timer)
+		})
+		manager.prewarmTimer = timer
+	}
+
+	manager.logger().Info().Dur("timeout", timeout).Msgf("pipeline prewarmed")
+	return manager.pauseIdle()
+}
+
+// ends prewarm, pipeline is destroyed (or paused if pause on idle is enabled)
+// unless it has listeners or is used by broadcast or recording
+func (manager *StreamSinkManagerCtx) Cooldown() {
+	manager.cooldown(nil)
+}
+
+// timer is set when called by expired prewarm timeout
+func (manager *StreamSinkManagerCtx) cooldown(timer *time.Timer) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.pipelineMu.Lock()
+	if !manager.prewarmed || (timer != nil && manager.prewarmTimer != timer) {
+		manager.pipelineMu.Unlock()
+		return
+	}
+
+	manager.stopPrewarm()
+	manager.pipelineMu.Unlock()
+
+	if timer != nil {
+		manager.logger().Info().Msgf("prewarm timed out, cooling down")
+	} else {
+		manager.logger().Info().Msgf("cooling down")
+	}
+
+	manager.stop()
+}
+
+func (manager *StreamSinkManagerCtx) Prewarmed() bool {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.prewarmed
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) stopPrewarm() {
+	if manager.prewarmTimer != nil {
+		manager.prewarmTimer.Stop()
+		manager.prewarmTimer = nil
+	}
+
+	manager.prewarmed = false
+}
+
+// pauses pipeline that is kept only because it is prewarmed or paused on idle,
+// e.g. after it was recreated, must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) pauseIdle() error {
+	if !(manager.prewarmed || manager.pauseOnIdle) || manager.pipeline == nil || manager.paused {
+		return nil
+	}
+
+	if manager.starting || manager.Started() || manager.used() {
+		return nil
+	}
+
+	if err := manager.pipeline.Pause(); err != nil {
+		return err
+	}
+
+	manager.paused = true
+	manager.stopPlaceholder()
+	manager.logger().Info().Msgf("idle pipeline paused")
+	return nil
+}
@@ -0,0 +1,142 @@
+package capture
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"m1k1o/neko/internal/types"
+)
+
+// prefix of names given to queues of the main chain, so that they can be
+// changed live. gstreamer names unnamed queues queue0, queue1, ...
+const queueNamePrefix = "tunedqueue"
+
+var queueLeakyModes = map[string]bool{"no": true, "upstream": true, "downstream": true}
+
+var queueNameRe = regexp.MustCompile(`(^|\s)name=(` + queueNamePrefix + `\d+)(\s|$)`)
+
+// props of queue for given settings, empty leaky and zero maxBuffers keep
+// defaults. max buffers replaces default time and bytes limits, so that it is
+// the only one
+func queueProps(leaky string, maxBuffers int) map[string]string {
+	props := map[string]string{}
+	if leaky != "" {
+		props["leaky"] = leaky
+	}
+	if maxBuffers > 0 {
+		props["max-size-buffers"] = strconv.Itoa(maxBuffers)
+		props["max-size-time"] = "0"
+		props["max-size-bytes"] = "0"
+	}
+	return props
+}
+
+// rewrite properties of all queues in pipeline string and names them, adds
+// properties if missing
+func setPipelineQueues(pipelineStr string, leaky string, maxBuffers int) (string, bool) {
+	elements := strings.Split(pipelineStr, "!")
+	props := queueProps(leaky, maxBuffers)
+
+	found := 0
+	for i, el := range elements {
+		fields := strings.Fields(el)
+		if len(fields) == 0 || fields[0] != "queue" {
+			continue
+		}
+
+		if !strings.Contains(el, "name=") {
+			props["name"] = fmt.Sprintf("%s%d", queueNamePrefix, found)
+		} else {
+			delete(props, "name")
+		}
+
+		for _, name := range sortedKeys(props) {
+			el = setElementProp(el, "queue", name, props[name])
+		}
+
+		elements[i] = el
+		found++
+	}
+
+	return strings.Join(elements, "!"), found > 0
+}
+
+// returns names of queues that were named by setPipelineQueues
+func pipelineQueueNames(pipelineStr string) []string {
+	var names []string
+	for _, el := range strings.Split(pipelineStr, "!") {
+		if match := queueNameRe.FindStringSubmatch(el); match != nil {
+			names = append(names, match[2])
+		}
+	}
+
+	return names
+}
+
+// sets how internal queues drop buffers when full: no, upstream (new ones) or
+// downstream (old ones). empty mode keeps the pipeline default. applied in
+// place to queues of the running pipeline if it has them named.
+func (manager *StreamSinkManagerCtx) SetQueueLeaky(mode string) error {
+	if mode != "" && !queueLeakyModes[mode] {
+		return fmt.Errorf("invalid queue leaky mode %q, must be no, upstream or downstream", mode)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.queueLeaky = mode
+	manager.logger().Info().Str("leaky", mode).Msgf("setting queue leaky mode")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return mode != "" && manager.setLiveQueueProps(pipeline, queueProps(mode, 0))
+	})
+}
+
+// limits internal queues to given number of buffers, fewer buffers lower
+// latency, more survive jitter. zero keeps the pipeline default. applied in
+// place to queues of the running pipeline if it has them named.
+func (manager *StreamSinkManagerCtx) SetQueueMaxBuffers(maxBuffers int) error {
+	if maxBuffers < 0 {
+		return fmt.Errorf("invalid queue max buffers %d, must not be negative", maxBuffers)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.queueMaxBuffers = maxBuffers
+	manager.logger().Info().Int("max_buffers", maxBuffers).Msgf("setting queue max buffers")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return maxBuffers > 0 && manager.setLiveQueueProps(pipeline, queueProps("", maxBuffers))
+	})
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) setLiveQueueProps(pipeline types.Pipeline, props map[string]string) bool {
+	names := pipelineQueueNames(pipeline.Src())
+	if len(names) == 0 {
+		return false
+	}
+
+	for _, name := range names {
+		for _, prop := range sortedKeys(props) {
+			if !pipeline.SetPropString(name, prop, props[prop]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func sortedKeys(props map[string]string) []string {
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,147 @@
+package capture
+
+import (
+	"fmt"
+	"time"
+)
+
+// returns manager to the state it had when it was created, so that it can be
+// reused, e.g. from a pool. pipeline is destroyed together with broadcast,
+// recording and hls, subscribers are closed, buffered samples and events are
+// released, and tunables, handlers, log level and counters are reset. codec and
+// pipeline builders are kept. it fails if the stream has listeners.
+func (manager *StreamSinkManagerCtx) Reset() error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if count := manager.ListenersCount(); count > 0 {
+		return fmt.Errorf("unable to reset stream with %d listeners", count)
+	}
+
+	manager.logger().Info().Msgf("resetting")
+
+	manager.adaptiveMu.Lock()
+	manager.adaptiveLimits = adaptiveLimits{}
+	manager.adaptiveParams = adaptiveParams{}
+	manager.adaptiveBitrate = 0
+	manager.adaptiveFramerateValue = 0
+	manager.adaptiveChangedAt = time.Time{}
+	manager.adaptiveMu.Unlock()
+
+	manager.pipelineMu.Lock()
+	manager.stopPrewarm()
+	if manager.pipeline != nil {
+		manager.teardownPipeline()
+	}
+	manager.stopPlaceholder()
+	manager.resetTunables()
+	manager.pipelineMu.Unlock()
+
+	manager.listenersMu.Lock()
+	manager.maxListeners = 0
+	manager.listenersHandlers = nil
+	manager.listenersMu.Unlock()
+
+	manager.playingHandlersMu.Lock()
+	manager.playingHandlers = nil
+	manager.playingHandlersMu.Unlock()
+
+	manager.sampleHooks.Store(nil)
+	manager.screenshotMaxAge.Store(int64(defaultScreenshotMaxAge))
+
+	// log level set by SetLogLevel is dropped
+	manager.currentLogger.Store(&manager.baseLogger)
+
+	manager.subscribersMu.Lock()
+	for id, subscriber := range manager.subscribers {
+		delete(manager.subscribers, id)
+		manager.closeSubscriber(subscriber)
+	}
+	manager.subscribersMu.Unlock()
+
+	// samples emitted before subscribers were closed are released
+	for _, samples := range manager.sampleChannels {
+		drainSamples(samples)
+	}
+
+	manager.eventsMu.Lock()
+	for len(manager.events) > 0 {
+		<-manager.events
+	}
+	manager.eventsMu.Unlock()
+
+	manager.resetCounters()
+	return nil
+}
+
+// must be called with pipelineMu held, pipeline must not exist
+func (manager *StreamSinkManagerCtx) resetTunables() {
+	manager.latestOnly = manager.Codec().IsVideo()
+	manager.leaseBuffers = false
+	manager.appsinkNames = map[string]string{}
+
+	manager.bitrate = 0
+	manager.changeFramerate = 0
+	manager.adaptiveFramerate = false
+	manager.minFramerate = 0
+	manager.maxFramerate = 0
+	manager.width = 0
+	manager.height = 0
+	manager.rateControl = ""
+	manager.encoderPreset = ""
+	manager.intraRefresh = false
+	manager.keyframeInterval = 0
+	manager.transform = ""
+	manager.qpMin = 0
+	manager.qpMax = 0
+	manager.queueLeaky = ""
+	manager.queueMaxBuffers = 0
+	manager.cursorSet = false
+	manager.cursorVisible = false
+	manager.region = nil
+	manager.display = ""
+	manager.audioSource = ""
+
+	manager.broadcastUrl = ""
+	manager.broadcastProtocol = ""
+	manager.broadcastError = nil
+	manager.broadcastConnected = false
+	manager.broadcastBitrateCap = 0
+	manager.recordingPath = ""
+	manager.recordingFormat = ""
+	manager.recordingBytes = 0
+	manager.recordingFiles = nil
+	manager.recordingError = nil
+	manager.recordingRebase = false
+	manager.hlsDir = ""
+	manager.hlsSegmentDuration = 0
+
+	manager.pauseOnIdle = false
+	manager.paused = false
+	manager.alwaysOn = false
+	manager.placeholder = false
+	manager.rtpOutput = false
+
+	manager.lastError = nil
+	manager.pipelineErrored = false
+	manager.pipelineCreatedAt = time.Time{}
+	manager.lastRestartAt = time.Time{}
+	manager.restartCount = 0
+}
+
+func (manager *StreamSinkManagerCtx) resetCounters() {
+	manager.lastSampleAt.Store(0)
+	manager.droppedSamples.Store(0)
+	manager.keyframes.Store(0)
+	manager.emittedSamples.Store(0)
+	manager.emittedBytes.Store(0)
+	for _, bytes := range manager.layerBytes {
+		bytes.Store(0)
+	}
+
+	manager.frameStats.reset()
+	manager.bitrateStats.reset()
+	manager.joinLatency.clear()
+	manager.resetFirstSample()
+	manager.healthy.Store(true)
+}
@@ -0,0 +1,108 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"m1k1o/neko/internal/types"
+	"m1k1o/neko/internal/utils"
+)
+
+// packets are small enough to fit typical path mtu with srtp and turn overhead
+const rtpOutputMTU = 1200
+
+// payloader elements of codecs, with properties matching webrtc expectations
+var rtpPayloaders = map[string]string{
+	// video
+	"vp8":     "rtpvp8pay picture-id-mode=15-bit",
+	"vp9":     "rtpvp9pay picture-id-mode=15-bit",
+	"vp9-svc": "rtpvp9pay picture-id-mode=15-bit",
+	"av1":     "rtpav1pay",
+	"h264":    "rtph264pay config-interval=-1 aggregate-mode=zero-latency",
+	// audio
+	"opus": "rtpopuspay",
+	"g722": "rtpg722pay",
+	"pcmu": "rtppcmupay",
+	"pcma": "rtppcmapay",
+}
+
+// inserts rtp payloader before appsinks with given names, so that they receive
+// rtp packets instead of encoded frames
+func setPipelineRTPOutput(pipelineStr string, appsinkNames []string, codecName string, payloadType uint8) (string, error) {
+	payloader, ok := rtpPayloaders[codecName]
+	if !ok {
+		return "", fmt.Errorf("codec %s does not support rtp output", codecName)
+	}
+
+	payloader = fmt.Sprintf(" %s pt=%d mtu=%d ! ", payloader, payloadType, rtpOutputMTU)
+
+	elements := strings.Split(pipelineStr, "!")
+	found := 0
+	for i, el := range elements {
+		fields := strings.Fields(el)
+		if len(fields) < 2 || fields[0] != "appsink" {
+			continue
+		}
+
+		for _, name := range appsinkNames {
+			if ok, _ := utils.ArrayIn("name="+name, fields[1:]); ok {
+				elements[i] = payloader + strings.TrimLeft(el, " ")
+				found++
+				break
+			}
+		}
+	}
+
+	if found < len(appsinkNames) {
+		return "", fmt.Errorf("%w: unable to find appsinks %s in pipeline", types.ErrPipelineParse, strings.Join(appsinkNames, ", "))
+	}
+
+	return strings.Join(elements, "!"), nil
+}
+
+// sets marker and timestamp of sample carrying rtp packet, false if it is not one
+func parseRTPSample(sample *types.Sample) bool {
+	// fixed header is 12 bytes, version is 2
+	if len(sample.Data) < 12 || sample.Data[0]>>6 != 2 {
+		return false
+	}
+
+	sample.RTP = true
+	sample.Marker = sample.Data[1]&0x80 != 0
+	sample.RTPTimestamp = binary.BigEndian.Uint32(sample.Data[4:8])
+	return true
+}
+
+// when enabled, pipeline payloads samples itself and subscribers receive rtp
+// packets with marker and timestamp set, that transport only forwards. it is
+// off by default, webrtc transport requires encoded frames. pipeline is
+// recreated to apply it.
+func (manager *StreamSinkManagerCtx) SetRTPOutput(enabled bool) error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if enabled {
+		if _, ok := rtpPayloaders[manager.Codec().Name]; !ok {
+			return fmt.Errorf("codec %s does not support rtp output", manager.Codec().Name)
+		}
+	}
+
+	if manager.rtpOutput == enabled {
+		return nil
+	}
+
+	manager.rtpOutput = enabled
+	manager.logger().Info().Bool("enabled", enabled).Msgf("setting rtp output")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+func (manager *StreamSinkManagerCtx) RTPOutput() bool {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.rtpOutput
+}
@@ -0,0 +1,300 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"m1k1o/neko/internal/capture/gst"
+	"m1k1o/neko/internal/types"
+)
+
+const (
+	screenshotTimeout = 5 * time.Second
+	// cached screenshots older than this are not served, see SetScreenshotMaxAge
+	defaultScreenshotMaxAge = 2 * time.Second
+	// tee of running pipeline, its branch passes raw frames to the appsink
+	screenshotName        = "screenshot"
+	screenshotAppsinkName = "appsinkscreenshot"
+)
+
+var screenshotCapsSize = regexp.MustCompile(`width=\(int\)(\d+).*height=\(int\)(\d+)`)
+
+type screenshotFormat struct {
+	encoder string
+	plugin  string
+	encode  func(w io.Writer, img image.Image) error
+}
+
+var (
+	screenshotJPEG = screenshotFormat{encoder: "jpegenc snapshot=true", plugin: "jpeg", encode: func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}}
+	screenshotPNG = screenshotFormat{encoder: "pngenc snapshot=true", plugin: "png", encode: png.Encode}
+)
+
+type screenshotCache struct {
+	mu   sync.Mutex
+	data []byte
+	at   time.Time
+}
+
+// latest raw frame of running pipeline
+type screenshotFrame struct {
+	mu     sync.Mutex
+	img    *image.RGBA
+	at     time.Time
+	stored chan struct{} // closed when next frame is stored
+}
+
+func (frame *screenshotFrame) store(img *image.RGBA) {
+	frame.mu.Lock()
+	defer frame.mu.Unlock()
+
+	frame.img = img
+	frame.at = time.Now()
+
+	if frame.stored != nil {
+		close(frame.stored)
+		frame.stored = nil
+	}
+}
+
+// returns frame not older than maxAge, waits for the next one otherwise
+func (frame *screenshotFrame) wait(maxAge time.Duration, timeout time.Duration) (*image.RGBA, error) {
+	frame.mu.Lock()
+	if frame.img != nil && time.Since(frame.at) < maxAge {
+		img := frame.img
+		frame.mu.Unlock()
+		return img, nil
+	}
+
+	if frame.stored == nil {
+		frame.stored = make(chan struct{})
+	}
+	stored := frame.stored
+	frame.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-stored:
+		frame.mu.Lock()
+		defer frame.mu.Unlock()
+		return frame.img, nil
+	case <-timer.C:
+		return nil, errors.New("timeout while waiting for screenshot")
+	}
+}
+
+// passes raw frames before encoder to screenshot appsink, rate is limited so
+// that converting frames nobody asked for is cheap
+func setPipelineScreenshot(pipelineStr string) (string, error) {
+	elements := strings.Split(pipelineStr, "!")
+
+	i, _, ok := findEncoder(elements)
+	if !ok {
+		return "", errors.New("unable to find encoder in pipeline")
+	}
+
+	if i == 0 {
+		return "", errors.New("pipeline does not contain raw source before encoder")
+	}
+
+	tee := fmt.Sprintf(" tee name=%s ", screenshotName)
+	elements = append(elements[:i], append([]string{tee, " queue "}, elements[i:]...)...)
+
+	branch := fmt.Sprintf("%s. ! queue leaky=downstream max-size-buffers=1 ! videorate drop-only=true ! video/x-raw,framerate=1/1 ! videoconvert ! video/x-raw,format=RGBA ! appsink name=%s",
+		screenshotName, screenshotAppsinkName)
+	return strings.Join(elements, "!") + " " + branch, nil
+}
+
+// replaces encoder and everything after it with image encoder, so that raw
+// frames from the capture source are used and nothing needs to be decoded
+func screenshotPipeline(pipelineStr string, format screenshotFormat) (string, error) {
+	elements := strings.Split(pipelineStr, "!")
+
+	i, _, ok := findEncoder(elements)
+	if !ok {
+		return "", errors.New("unable to find encoder in pipeline")
+	}
+
+	if i == 0 {
+		return "", errors.New("pipeline does not contain raw source before encoder")
+	}
+
+	elements = append(elements[:i], " videoconvert ", " "+format.encoder+" ", " appsink name="+screenshotAppsinkName)
+	return strings.Join(elements, "!"), nil
+}
+
+// captures single frame as JPEG, works without listeners
+func (manager *StreamSinkManagerCtx) Screenshot() ([]byte, error) {
+	return manager.screenshot(&manager.screenshotJPEG, screenshotJPEG)
+}
+
+// captures single frame as PNG, works without listeners
+func (manager *StreamSinkManagerCtx) ScreenshotPNG() ([]byte, error) {
+	return manager.screenshot(&manager.screenshotPNG, screenshotPNG)
+}
+
+// screenshots older than maxAge are not served from cache, zero disables the
+// cache. it is also the max age of frame of running pipeline screenshot is taken from.
+func (manager *StreamSinkManagerCtx) SetScreenshotMaxAge(maxAge time.Duration) error {
+	if maxAge < 0 {
+		return fmt.Errorf("invalid screenshot max age %s", maxAge)
+	}
+
+	manager.screenshotMaxAge.Store(int64(maxAge))
+	return nil
+}
+
+// serves recent cached screenshot, otherwise takes new one. concurrent callers
+// wait for and share the same capture.
+func (manager *StreamSinkManagerCtx) screenshot(cache *screenshotCache, format screenshotFormat) ([]byte, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	maxAge := time.Duration(manager.screenshotMaxAge.Load())
+	if cache.data != nil && time.Since(cache.at) < maxAge {
+		return cache.data, nil
+	}
+
+	data, err := manager.takeScreenshot(format, maxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.data = data
+	cache.at = time.Now()
+	return data, nil
+}
+
+// encodes frame of running pipeline, without it captures single frame using
+// a transient pipeline built from the stream pipeline
+func (manager *StreamSinkManagerCtx) takeScreenshot(format screenshotFormat, maxAge time.Duration) ([]byte, error) {
+	if rtpCodec := manager.Codec(); !rtpCodec.IsVideo() {
+		return nil, fmt.Errorf("unable to take screenshot of %s stream", rtpCodec.Name)
+	}
+
+	manager.pipelineMu.Lock()
+	pipelineStr := manager.pipelineSrc()
+	// paused pipeline does not produce frames
+	tapped := manager.pipeline != nil && !manager.paused &&
+		strings.Contains(pipelineStr, "name="+screenshotAppsinkName)
+	manager.pipelineMu.Unlock()
+
+	if tapped {
+		img, err := manager.screenshotFrame.wait(maxAge, screenshotTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := format.encode(&buf, img); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	if pipelineStr == "" {
+		return nil, errors.New("unable to get stream pipeline")
+	}
+
+	pipelineStr, err := screenshotPipeline(pipelineStr, format)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gst.CheckPlugins([]string{format.plugin}); err != nil {
+		return nil, err
+	}
+
+	manager.logger().Debug().Str("src", pipelineStr).Msg("creating screenshot pipeline")
+
+	pipeline, err := gst.CreatePipeline(pipelineStr)
+	if err != nil {
+		return nil, err
+	}
+	defer pipeline.Destroy()
+
+	samples := make(chan types.Sample, 1)
+	if err := pipeline.AttachAppsink(screenshotAppsinkName, samples); err != nil {
+		return nil, err
+	}
+
+	if err := pipeline.Play(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case sample := <-samples:
+		return sample.Data, nil
+	case <-time.After(screenshotTimeout):
+		return nil, errors.New("timeout while waiting for screenshot")
+	}
+}
+
+// keeps latest raw frame of pipeline until it is destroyed
+func (manager *StreamSinkManagerCtx) watchScreenshotFrames(pipeline types.Pipeline, samples chan types.Sample) {
+	var width, height int
+
+	for {
+		select {
+		case <-pipeline.Destroyed():
+			return
+		case sample := <-samples:
+			// caps are known once frames flow
+			if width == 0 || height == 0 {
+				caps, _ := pipeline.GetPadCaps(screenshotAppsinkName, "sink")
+				width, height = screenshotSize(caps)
+			}
+
+			if img, ok := screenshotImage(sample, width, height); ok {
+				manager.screenshotFrame.store(img)
+			}
+
+			sample.Release()
+		}
+	}
+}
+
+func screenshotSize(caps string) (width, height int) {
+	match := screenshotCapsSize.FindStringSubmatch(caps)
+	if match == nil {
+		return 0, 0
+	}
+
+	width, _ = strconv.Atoi(match[1])
+	height, _ = strconv.Atoi(match[2])
+	return width, height
+}
+
+// leased data is copied, because it is valid only until sample is released
+func screenshotImage(sample types.Sample, width, height int) (*image.RGBA, bool) {
+	if width <= 0 || height <= 0 || len(sample.Data) != width*height*4 {
+		return nil, false
+	}
+
+	pix := sample.Data
+	if sample.Lease != nil {
+		pix = make([]byte, len(sample.Data))
+		copy(pix, sample.Data)
+	}
+
+	return &image.RGBA{
+		Pix:    pix,
+		Stride: width * 4,
+		Rect:   image.Rect(0, 0, width, height),
+	}, true
+}
@@ -0,0 +1,198 @@
+package capture
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"m1k1o/neko/internal/types"
+)
+
+// capture source needed by pipelines of several stream managers, e.g. audio
+// device captured by audio stream and joined to hls of video stream. while
+// only one pipeline needs it, that pipeline captures it directly. when more
+// pipelines need it, it is captured once by its own pipeline and they read
+// from it, so that teardown of one manager never stops capture of another.
+// the last pipeline reading from it stops it.
+type sharedSource struct {
+	logger zerolog.Logger
+	// source elements as pipelines of managers contain them, they are replaced
+	// by consumer elements reading from the shared pipeline
+	direct   string
+	consumer string
+	// pipeline capturing the source
+	src string
+
+	mu sync.Mutex
+	// managers whose pipelines need the source, true if the pipeline reads
+	// from the shared pipeline
+	holders  map[*StreamSinkManagerCtx]bool
+	pipeline types.Pipeline
+
+	createPipelineFn func(pipelineStr string) (types.Pipeline, error)
+}
+
+// shares pulseaudio device captured with given params, inter plugin is required
+func newSharedAudioSource(device string, params AudioParams) *sharedSource {
+	channel := "neko-audio-" + device
+	caps := params.caps()
+
+	return &sharedSource{
+		logger: log.With().
+			Str("module", "capture").
+			Str("submodule", "shared-source").
+			Str("channel", channel).Logger(),
+		direct:           fmt.Sprintf(audioSrc, device, caps),
+		consumer:         fmt.Sprintf("interaudiosrc channel=%s ! audio/x-raw,%s ! audioconvert ! ", channel, caps),
+		src:              fmt.Sprintf("pulsesrc device=%s ! audio/x-raw,%s ! interaudiosink channel=%s", device, caps, channel),
+		holders:          map[*StreamSinkManagerCtx]bool{},
+		createPipelineFn: createGstPipeline,
+	}
+}
+
+// registers pipeline string of manager that is about to be created, false if
+// it does not capture the source. returned string reads from the shared
+// pipeline if another manager needs the source too, such managers are then
+// recreated to read from it as well. manager stays registered until release,
+// so that recreating its pipeline does not recreate pipelines of others.
+func (source *sharedSource) acquire(manager *StreamSinkManagerCtx, pipelineStr string) (string, bool, error) {
+	if !strings.Contains(pipelineStr, source.direct) {
+		return pipelineStr, false, nil
+	}
+
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	_, held := source.holders[manager]
+	holders := len(source.holders)
+	if !held {
+		holders++
+	}
+
+	if holders == 1 {
+		source.holders[manager] = false
+		source.stopUnused()
+		return pipelineStr, true, nil
+	}
+
+	if source.pipeline == nil {
+		source.logger.Info().Str("src", source.src).Msgf("starting shared source")
+
+		pipeline, err := source.createPipelineFn(source.src)
+		if err != nil {
+			return "", false, err
+		}
+
+		if err := pipeline.Play(); err != nil {
+			pipeline.Destroy()
+			return "", false, err
+		}
+
+		source.pipeline = pipeline
+	}
+
+	source.holders[manager] = true
+	if !held {
+		source.refreshHolders()
+	}
+
+	return strings.ReplaceAll(pipelineStr, source.direct, source.consumer), true, nil
+}
+
+// unregisters manager whose pipeline no longer needs the source, remaining
+// manager stops reading from the shared pipeline
+func (source *sharedSource) release(manager *StreamSinkManagerCtx) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	if _, ok := source.holders[manager]; !ok {
+		return
+	}
+
+	delete(source.holders, manager)
+	source.refreshHolders()
+	source.stopUnused()
+}
+
+// must be called with mu held
+func (source *sharedSource) stale(manager *StreamSinkManagerCtx) bool {
+	shared, ok := source.holders[manager]
+	return ok && shared != (len(source.holders) > 1)
+}
+
+// recreates pipelines of managers that read the source the other way than
+// they should, outside of the lock because they take their own locks.
+// must be called with mu held
+func (source *sharedSource) refreshHolders() {
+	for manager := range source.holders {
+		if source.stale(manager) {
+			go manager.refreshSharedSource(source)
+		}
+	}
+}
+
+// stops shared pipeline when nobody reads from it, must be called with mu held
+func (source *sharedSource) stopUnused() {
+	if source.pipeline == nil {
+		return
+	}
+
+	for _, shared := range source.holders {
+		if shared {
+			return
+		}
+	}
+
+	source.pipeline.Destroy()
+	source.pipeline = nil
+	source.logger.Info().Msgf("shared source stopped")
+}
+
+// recreates pipeline if it still reads the source the other way than it
+// should, state might have changed since refresh was requested
+func (manager *StreamSinkManagerCtx) refreshSharedSource(source *sharedSource) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return
+	}
+
+	source.mu.Lock()
+	stale := source.stale(manager)
+	source.mu.Unlock()
+
+	if !stale {
+		return
+	}
+
+	manager.logger().Info().Msgf("shared source changed, recreating pipeline")
+	manager.teardownPipeline()
+	if err := manager.buildPipeline(); err != nil {
+		manager.logger().Err(err).Msg("unable to recreate pipeline")
+	}
+}
+
+// releases shared sources that the current pipeline does not hold, it waits
+// for pipelineMu so that pipeline being recreated can acquire them again
+func (manager *StreamSinkManagerCtx) releaseSharedSources() {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	for _, source := range manager.sharedSources {
+		held := false
+		for _, acquired := range manager.acquiredSources {
+			if acquired == source {
+				held = true
+				break
+			}
+		}
+
+		if !held {
+			source.release(manager)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package capture
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waits until pipeline of manager reads audio from given element
+func waitAudioSrc(t *testing.T, manager *StreamSinkManagerCtx, element string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		p := currentPipeline(manager)
+		if p != nil && strings.HasPrefix(p.Src(), element) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pipeline does not read audio from %s", element)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSharedSourceOnlyWhileShared(t *testing.T) {
+	sources := &fakePipelines{}
+	source := newSharedAudioSource("test", AudioParams{})
+	source.createPipelineFn = sources.create
+
+	pipelineFn := func(params PipelineParams) (string, error) {
+		return fmt.Sprintf(audioSrc, "test", AudioParams{}.caps()) + "opusenc name=encoder ! appsink name=appsinkvideo", nil
+	}
+
+	first := newTestManager(t, &fakePipelines{})
+	first.pipelineFn = pipelineFn
+	first.sharedSources = []*sharedSource{source}
+
+	second := newTestManager(t, &fakePipelines{})
+	second.pipelineFn = pipelineFn
+	second.sharedSources = []*sharedSource{source}
+
+	// single holder captures the device directly
+	if err := first.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+	waitAudioSrc(t, first, "pulsesrc")
+	if created, _, _ := sources.count(); created != 0 {
+		t.Fatalf("shared source created for single holder")
+	}
+
+	// both holders read from the shared source
+	if err := second.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+	waitAudioSrc(t, second, "interaudiosrc")
+	waitAudioSrc(t, first, "interaudiosrc")
+	if _, alive, _ := sources.count(); alive != 1 {
+		t.Fatalf("alive shared sources = %d, want 1", alive)
+	}
+
+	// remaining holder captures the device directly again
+	if err := second.RemoveListener(); err != nil {
+		t.Fatal(err)
+	}
+	waitAudioSrc(t, first, "pulsesrc")
+	if _, alive, _ := sources.count(); alive != 0 {
+		t.Fatalf("alive shared sources = %d, want 0", alive)
+	}
+}
@@ -0,0 +1,83 @@
+package capture
+
+import (
+	"time"
+
+	"m1k1o/neko/internal/types"
+)
+
+// how often display size is compared with the size pipeline was created for
+const sourceSizeCheckInterval = time.Second
+
+// recreates pipeline when captured display was resized, e.g. by RandR outside
+// of neko, because caps of the running source would be stale. listeners are kept.
+func (manager *StreamSinkManagerCtx) SetDisplayResolution(width, height int) error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	// new pipeline will be created for current size
+	if manager.pipeline == nil {
+		return nil
+	}
+
+	if manager.sourceWidth == width && manager.sourceHeight == height {
+		return nil
+	}
+
+	manager.logger().Info().
+		Int("width", width).
+		Int("height", height).
+		Msgf("display resolution changed from %dx%d, recreating pipeline", manager.sourceWidth, manager.sourceHeight)
+
+	// paused pipeline without listeners must not start playing
+	if manager.paused {
+		manager.teardownPipeline()
+		if !manager.active() {
+			return nil
+		}
+		return manager.buildPipeline()
+	}
+
+	// source caps cannot be renegotiated in place
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+// must be called with pipelineMu held, when pipeline is created
+func (manager *StreamSinkManagerCtx) updateSourceSize() {
+	if manager.displaySizeFn == nil {
+		return
+	}
+
+	manager.sourceWidth, manager.sourceHeight = manager.displaySizeFn()
+}
+
+// starts periodic check of display size, it requires displaySizeFn. must be
+// called before shutdown, exits on shutdown
+func (manager *StreamSinkManagerCtx) watchSourceSize() {
+	manager.wg.Add(1)
+	go func() {
+		defer manager.wg.Done()
+
+		ticker := time.NewTicker(sourceSizeCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-manager.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			width, height := manager.displaySizeFn()
+			if width == 0 || height == 0 {
+				continue
+			}
+
+			if err := manager.SetDisplayResolution(width, height); err != nil {
+				manager.logger().Err(err).Msg("unable to recreate pipeline after display resolution change")
+			}
+		}
+	}()
+}
@@ -0,0 +1,178 @@
+package capture
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"m1k1o/neko/internal/types"
+)
+
+// samples of batch subscriber waiting to be delivered together
+type sampleBatch struct {
+	mu       sync.Mutex
+	max      int
+	delay    time.Duration
+	pending  []types.Sample
+	deadline time.Time // when pending samples are delivered even if batch is not full
+	batches  chan []types.Sample
+}
+
+func (batch *sampleBatch) add(sample types.Sample, now time.Time) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	if len(batch.pending) == 0 {
+		batch.deadline = now.Add(batch.delay)
+	}
+
+	batch.pending = append(batch.pending, sample)
+}
+
+// returns pending samples if batch is full or its deadline passed
+func (batch *sampleBatch) take(now time.Time) ([]types.Sample, time.Time) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	if len(batch.pending) == 0 {
+		return nil, time.Time{}
+	}
+
+	if len(batch.pending) < batch.max && now.Before(batch.deadline) {
+		return nil, batch.deadline
+	}
+
+	samples := batch.pending
+	batch.pending = make([]types.Sample, 0, batch.max)
+	return samples, time.Time{}
+}
+
+// releases samples that were not delivered, returns their number
+func (batch *sampleBatch) drain() int {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	drained := len(batch.pending)
+	for _, sample := range batch.pending {
+		sample.Release()
+	}
+	batch.pending = batch.pending[:0]
+
+	for {
+		select {
+		case samples := <-batch.batches:
+			for _, sample := range samples {
+				sample.Release()
+			}
+			drained += len(samples)
+		default:
+			return drained
+		}
+	}
+}
+
+// pending samples are released, delivered batches are left to the receiver
+func (batch *sampleBatch) close() {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	for _, sample := range batch.pending {
+		sample.Release()
+	}
+	batch.pending = nil
+
+	close(batch.batches)
+}
+
+// subscribes to default layer, samples are delivered in slices of up to
+// maxSamples, or fewer once the first of them waited maxDelay. it lowers
+// overhead of high rate streams, e.g. audio, where consumer handles samples
+// together anyway. receiver owns samples and must release them.
+func (manager *StreamSinkManagerCtx) SubscribeBatch(id string, maxSamples int, maxDelay time.Duration) (<-chan []types.Sample, error) {
+	if maxSamples <= 0 {
+		return nil, fmt.Errorf("invalid batch size %d, must be positive", maxSamples)
+	}
+
+	if maxDelay <= 0 {
+		return nil, fmt.Errorf("invalid batch delay %s, must be positive", maxDelay)
+	}
+
+	// buffer holds about as many samples as the one of regular subscriber
+	buffer := subscriberBufferSize / maxSamples
+	if buffer < 2 {
+		buffer = 2
+	}
+
+	batch := &sampleBatch{
+		max:     maxSamples,
+		delay:   maxDelay,
+		pending: make([]types.Sample, 0, maxSamples),
+		batches: make(chan []types.Sample, buffer),
+	}
+
+	manager.subscribersMu.Lock()
+	defer manager.subscribersMu.Unlock()
+
+	if _, ok := manager.subscribers[id]; ok {
+		return nil, fmt.Errorf("subscriber %s already exists", id)
+	}
+
+	manager.subscribers[id] = &streamSubscriber{
+		batch: batch,
+		layer: manager.layers[0],
+	}
+	manager.batchSubscribers.Add(1)
+
+	manager.logger().Debug().
+		Str("subscriber", id).
+		Int("max_samples", maxSamples).
+		Dur("max_delay", maxDelay).
+		Msg("subscribed to batches")
+
+	return batch.batches, nil
+}
+
+// delivers batches of layer that are full or waited long enough, returns
+// number of dropped samples and deadline of the earliest remaining batch
+func (manager *StreamSinkManagerCtx) flushBatches(layer string, now time.Time) (uint64, time.Time) {
+	var dropped uint64
+	var next time.Time
+
+	manager.subscribersMu.RLock()
+	defer manager.subscribersMu.RUnlock()
+
+	for id, subscriber := range manager.subscribers {
+		if subscriber.batch == nil || subscriber.layer != layer {
+			continue
+		}
+
+		samples, deadline := subscriber.batch.take(now)
+		if samples == nil {
+			if !deadline.IsZero() && (next.IsZero() || deadline.Before(next)) {
+				next = deadline
+			}
+			continue
+		}
+
+		var bytes uint64
+		for _, sample := range samples {
+			bytes += uint64(len(sample.Data))
+		}
+
+		select {
+		case subscriber.batch.batches <- samples:
+			subscriber.deliveredSamples.Add(uint64(len(samples)))
+			subscriber.deliveredBytes.Add(bytes)
+		default:
+			for _, sample := range samples {
+				sample.Release()
+			}
+			subscriber.droppedSamples.Add(uint64(len(samples)))
+			manager.droppedSamples.Add(uint64(len(samples)))
+			dropped += uint64(len(samples))
+			manager.logger().Trace().Str("subscriber", id).Int("samples", len(samples)).Msg("subscriber buffer is full, dropping batch")
+		}
+	}
+
+	return dropped, next
+}
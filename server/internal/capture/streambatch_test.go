@@ -0,0 +1,84 @@
+package capture
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+
+	"m1k1o/neko/internal/types"
+)
+
+const benchmarkSubscribers = 4
+
+// delivers samples emitted by pipeline to subscribers receiving them one by
+// one or in batches, dropped samples are counted as delivered
+func benchmarkDelivery(b *testing.B, batchSize int) {
+	manager := newTestManager(b, &fakePipelines{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < benchmarkSubscribers; i++ {
+		id := fmt.Sprintf("subscriber%d", i)
+
+		if batchSize > 0 {
+			batches, err := manager.SubscribeBatch(id, batchSize, 10*time.Millisecond)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for batch := range batches {
+					for _, sample := range batch {
+						sample.Release()
+					}
+				}
+			}()
+		} else {
+			samples, err := manager.Subscribe(id)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for sample := range samples {
+					sample.Release()
+				}
+			}()
+		}
+	}
+
+	data := make([]byte, 1200)
+	samples := manager.sampleChannels[manager.layers[0]]
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		samples <- types.Sample{Sample: media.Sample{Data: data}, PTS: -1}
+	}
+
+	// wait until emit goroutine took all samples
+	for len(samples) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.StopTimer()
+
+	// subscribers are closed by shutdown
+	manager.shutdown()
+	wg.Wait()
+}
+
+func BenchmarkDeliverySamples(b *testing.B) {
+	benchmarkDelivery(b, 0)
+}
+
+func BenchmarkDeliveryBatches(b *testing.B) {
+	benchmarkDelivery(b, 32)
+}
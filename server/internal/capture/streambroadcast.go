@@ -0,0 +1,241 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"m1k1o/neko/internal/types"
+)
+
+// elements of broadcast branch are prefixed with this name, so that their
+// errors can be told apart from errors of the stream itself
+const broadcastName = "broadcast"
+
+// how long destroyed pipeline waits for broadcast muxer to flush
+const broadcastFinalizeTimeout = 3 * time.Second
+
+const (
+	broadcastProtocolRTMP = "rtmp"
+	broadcastProtocolSRT  = "srt"
+)
+
+const broadcastBranch = "%s. ! queue leaky=downstream max-size-buffers=60 ! videoconvert ! x264enc name=%sencoder bframes=0 key-int-max=60 byte-stream=true tune=zerolatency speed-preset=veryfast ! h264parse ! "
+
+// muxer and sink of broadcast branch for each protocol, srt carries mpeg-ts
+var broadcastSinks = map[string]string{
+	broadcastProtocolRTMP: "flvmux name=%smux streamable=true ! rtmpsink name=%ssink location='%s live=1'",
+	// pipeline must not wait for the listener to accept, dropped connection
+	// is reported as error and reestablished by restarting the pipeline
+	broadcastProtocolSRT: "mpegtsmux name=%smux ! srtsink name=%ssink uri='%s' wait-for-connection=false",
+}
+
+var broadcastPlugins = map[string][]string{
+	broadcastProtocolRTMP: {"x264", "videoparsersbad", "flv", "rtmp"},
+	broadcastProtocolSRT:  {"x264", "videoparsersbad", "mpegtsmux", "srt"},
+}
+
+// splits raw video before the encoder with a tee and appends branch that
+// encodes it again and pushes it to the RTMP or SRT url, the stream is not
+// affected. bitrateCap in kbit/s limits bitrate of the branch encoder, zero
+// for default.
+func setPipelineBroadcast(pipelineStr string, protocol string, url string, bitrateCap int) (string, error) {
+	sink, ok := broadcastSinks[protocol]
+	if !ok {
+		return "", fmt.Errorf("unknown broadcast protocol %s", protocol)
+	}
+
+	elements := strings.Split(pipelineStr, "!")
+
+	i, _, ok := findEncoder(elements)
+	if !ok {
+		return "", fmt.Errorf("%w: unable to find encoder in pipeline", types.ErrPipelineParse)
+	}
+
+	if i == 0 {
+		return "", fmt.Errorf("%w: pipeline does not contain raw source before encoder", types.ErrPipelineParse)
+	}
+
+	tee := fmt.Sprintf(" tee name=%s ", broadcastName)
+	elements = append(elements[:i], append([]string{tee, " queue "}, elements[i:]...)...)
+
+	branch := fmt.Sprintf(broadcastBranch, broadcastName, broadcastName) + fmt.Sprintf(sink, broadcastName, broadcastName, url)
+	if bitrateCap > 0 {
+		encoder := "name=" + broadcastName + "encoder"
+		branch = strings.Replace(branch, encoder, fmt.Sprintf("%s bitrate=%d", encoder, bitrateCap), 1)
+	}
+
+	return strings.Join(elements, "!") + " " + branch, nil
+}
+
+// errors reported by elements of broadcast branch
+func isBroadcastError(err error) bool {
+	return strings.Contains(err.Error(), "element "+broadcastName)
+}
+
+// pushes captured video to RTMP url in addition to the stream, the same capture
+// is used, only audio is not included. pipeline is kept running while
+// broadcasting even without listeners, and recreated when connection drops.
+func (manager *StreamSinkManagerCtx) StartBroadcast(url string) error {
+	return manager.startBroadcast(broadcastProtocolRTMP, url)
+}
+
+// the same as StartBroadcast, but pushes mpeg-ts over SRT in caller mode, e.g.
+// srt://host:port. replaces RTMP broadcast if it is running.
+func (manager *StreamSinkManagerCtx) StartBroadcastSRT(url string) error {
+	if url != "" && !strings.HasPrefix(url, "srt://") {
+		return fmt.Errorf("invalid srt url %s, must start with srt://", url)
+	}
+
+	return manager.startBroadcast(broadcastProtocolSRT, url)
+}
+
+func (manager *StreamSinkManagerCtx) startBroadcast(protocol string, url string) error {
+	if rtpCodec := manager.Codec(); !rtpCodec.IsVideo() {
+		return fmt.Errorf("unable to broadcast %s stream", rtpCodec.Name)
+	}
+
+	if url == "" {
+		return errors.New("broadcast url must not be empty")
+	}
+
+	if err := manager.checkPluginsFn(broadcastPlugins[protocol]); err != nil {
+		return err
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	// fail early, so that stream is not recreated with unusable pipeline
+	if _, err := setPipelineBroadcast(manager.pipelineSrc(), protocol, url, manager.broadcastBitrateCap); err != nil {
+		return err
+	}
+
+	manager.broadcastUrl = url
+	manager.broadcastProtocol = protocol
+	manager.broadcastError = nil
+	manager.broadcastConnected = false
+	manager.logger().Info().Str("protocol", protocol).Str("url", url).Msgf("starting broadcast")
+
+	if manager.pipeline == nil {
+		return manager.buildPipeline()
+	}
+
+	// branch cannot be added to running pipeline
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+func (manager *StreamSinkManagerCtx) StopBroadcast() error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.broadcastUrl == "" {
+		return nil
+	}
+
+	manager.logger().Info().Msgf("stopping broadcast")
+
+	manager.broadcastConnected = false
+
+	if manager.pipeline == nil {
+		manager.broadcastUrl = ""
+		return nil
+	}
+
+	// pipeline is recreated or destroyed anyway, so it can be ended as a whole
+	manager.finishMuxers()
+	manager.broadcastUrl = ""
+
+	// pipeline was kept only because of broadcast
+	if !manager.active() {
+		manager.teardownPipeline()
+		return nil
+	}
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+// lets muxers of broadcast and hls branches finalize their output before pipeline
+// is destroyed, must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) finishMuxers() {
+	// failed or paused pipeline would only time out
+	if manager.broadcastUrl == "" && manager.hlsDir == "" || manager.pipeline.State() != "PLAYING" {
+		return
+	}
+
+	if err := manager.pipeline.EndOfStream(broadcastFinalizeTimeout); err != nil {
+		manager.logger().Warn().Err(err).Msg("unable to finalize muxers")
+	}
+}
+
+func (manager *StreamSinkManagerCtx) Broadcasting() bool {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.broadcastUrl != ""
+}
+
+func (manager *StreamSinkManagerCtx) BroadcastStatus() types.StreamBroadcastStatus {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	status := types.StreamBroadcastStatus{
+		Active:     manager.broadcastUrl != "",
+		Protocol:   manager.broadcastProtocol,
+		Url:        manager.broadcastUrl,
+		BitrateCap: manager.broadcastBitrateCap,
+		LastError:  manager.broadcastError,
+	}
+
+	status.Running = status.Active && manager.pipeline != nil && manager.pipeline.State() == "PLAYING"
+	status.Connected = status.Running && manager.broadcastConnected
+	return status
+}
+
+// limits bitrate of broadcast in kbit/s independently of the stream, so that
+// bandwidth limited endpoint is not overwhelmed, zero removes the limit.
+// applied in place while broadcasting if it is set, kept for next broadcasts.
+func (manager *StreamSinkManagerCtx) SetBroadcastBitrateCap(kbps int) error {
+	if kbps < 0 {
+		return fmt.Errorf("invalid broadcast bitrate cap %d, must not be negative", kbps)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.broadcastBitrateCap = kbps
+	manager.logger().Info().Int("bitrate_cap", kbps).Msgf("setting broadcast bitrate cap")
+
+	if manager.broadcastUrl == "" {
+		return nil
+	}
+
+	// removed cap needs default bitrate of the encoder, pipeline is recreated
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return kbps > 0 && pipeline.SetPropInt(broadcastName+"encoder", "bitrate", kbps)
+	})
+}
+
+// pipeline is needed by broadcast, recording, hls or is always on regardless of listeners
+func (manager *StreamSinkManagerCtx) inUse() bool {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.used()
+}
+
+// pipeline is needed by listeners, broadcast, recording, hls, is prewarmed or
+// always on, must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) active() bool {
+	return manager.used() || manager.prewarmed || manager.Started()
+}
+
+// pipeline is needed regardless of listeners, must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) used() bool {
+	return manager.broadcastUrl != "" || manager.recordingPath != "" || manager.hlsDir != "" || manager.alwaysOn
+}
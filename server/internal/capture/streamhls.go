@@ -0,0 +1,174 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"m1k1o/neko/internal/types"
+)
+
+// elements of hls branch are prefixed with this name
+const hlsName = "hls"
+
+const (
+	hlsPlaylistName = "playlist.m3u8"
+	hlsSegmentName  = "segment%05d.ts"
+	// segments listed in the playlist
+	hlsPlaylistLength = 6
+	// segments kept on disk, a few more than listed so that clients still
+	// downloading old ones do not fail, older ones are deleted
+	hlsMaxFiles = 10
+)
+
+// splits raw video before the encoder with a tee and appends branch that encodes
+// it again to h264 and segments it to mpeg-ts files with rolling playlist in dir.
+// audioSrc is raw audio source joined to the segments, empty for video only.
+func setPipelineHLS(pipelineStr string, dir string, segmentDuration time.Duration, framerate int16, audioSrc string) (string, error) {
+	elements := strings.Split(pipelineStr, "!")
+
+	i, _, ok := findEncoder(elements)
+	if !ok {
+		return "", fmt.Errorf("%w: unable to find encoder in pipeline", types.ErrPipelineParse)
+	}
+
+	if i == 0 {
+		return "", fmt.Errorf("%w: pipeline does not contain raw source before encoder", types.ErrPipelineParse)
+	}
+
+	tee := fmt.Sprintf(" tee name=%s ", hlsName)
+	elements = append(elements[:i], append([]string{tee, " queue "}, elements[i:]...)...)
+
+	// segments can be split only on keyframes
+	seconds := int(math.Ceil(segmentDuration.Seconds()))
+	keyframeDist := 60
+	if framerate > 0 {
+		keyframeDist = int(framerate) * seconds
+	}
+
+	branch := strings.Join([]string{
+		fmt.Sprintf("hlssink2 name=%ssink location='%s' playlist-location='%s' target-duration=%d playlist-length=%d max-files=%d",
+			hlsName, filepath.Join(dir, hlsSegmentName), filepath.Join(dir, hlsPlaylistName), seconds, hlsPlaylistLength, hlsMaxFiles),
+		fmt.Sprintf("%s. ! queue leaky=downstream max-size-buffers=60 ! videoconvert ! x264enc name=%sencoder bframes=0 key-int-max=%d byte-stream=true tune=zerolatency speed-preset=veryfast ! h264parse ! %ssink.video", hlsName, hlsName, keyframeDist, hlsName),
+	}, " ")
+
+	if audioSrc != "" {
+		// captured opus is not supported by mpeg-ts, audio is transcoded to aac
+		aacStr, err := NewAACPipeline("", 0)
+		if err != nil {
+			return "", err
+		}
+
+		branch += fmt.Sprintf(" %squeue ! %s ! %ssink.audio", audioSrc, aacStr, hlsName)
+	}
+
+	return strings.Join(elements, "!") + " " + branch, nil
+}
+
+// segments captured video to HLS in dir, rolling playlist is written to
+// playlist.m3u8 and old segments are deleted. the same capture is used,
+// pipeline is kept running while it is active even without listeners.
+func (manager *StreamSinkManagerCtx) StartHLS(dir string, segmentDuration time.Duration) error {
+	if rtpCodec := manager.Codec(); !rtpCodec.IsVideo() {
+		return fmt.Errorf("unable to start hls of %s stream", rtpCodec.Name)
+	}
+
+	if dir == "" {
+		return errors.New("hls directory must not be empty")
+	}
+
+	if segmentDuration < time.Second {
+		return fmt.Errorf("invalid hls segment duration %s, must be at least 1s", segmentDuration)
+	}
+
+	plugins := []string{"hls", "mpegtsmux", "x264", "videoparsersbad"}
+	if manager.hlsAudioSrc != "" {
+		plugins = append(plugins, "pulseaudio")
+	}
+
+	if err := manager.checkPluginsFn(plugins); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.hlsDir != "" {
+		return fmt.Errorf("hls is already written to %s", manager.hlsDir)
+	}
+
+	// fail early, so that stream is not recreated with unusable pipeline
+	if _, err := setPipelineHLS(manager.pipelineSrc(), dir, segmentDuration, manager.framerate(), manager.hlsAudioSrc); err != nil {
+		return err
+	}
+
+	manager.hlsDir = dir
+	manager.hlsSegmentDuration = segmentDuration
+	manager.logger().Info().Str("dir", dir).Dur("segment_duration", segmentDuration).Msgf("starting hls")
+
+	if manager.pipeline == nil {
+		return manager.buildPipeline()
+	}
+
+	// branch cannot be added to running pipeline
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+// stops segmenting, already written files are kept
+func (manager *StreamSinkManagerCtx) StopHLS() error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.hlsDir == "" {
+		return nil
+	}
+
+	manager.logger().Info().Msgf("stopping hls")
+
+	if manager.pipeline == nil {
+		manager.hlsDir = ""
+		return nil
+	}
+
+	// pipeline is recreated or destroyed anyway, so it can be ended as a whole
+	manager.finishMuxers()
+	manager.hlsDir = ""
+
+	// pipeline was kept only because of hls
+	if !manager.active() {
+		manager.teardownPipeline()
+		return nil
+	}
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+func (manager *StreamSinkManagerCtx) HLSStatus() types.StreamHLSStatus {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	status := types.StreamHLSStatus{
+		Active: manager.hlsDir != "",
+		Dir:    manager.hlsDir,
+	}
+
+	if status.Active {
+		status.SegmentDuration = manager.hlsSegmentDuration
+		status.Playlist = filepath.Join(manager.hlsDir, hlsPlaylistName)
+		status.Running = manager.pipeline != nil && manager.pipeline.State() == "PLAYING"
+	}
+
+	return status
+}
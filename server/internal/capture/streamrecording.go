@@ -0,0 +1,263 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"m1k1o/neko/internal/types"
+	"m1k1o/neko/internal/utils"
+)
+
+// elements of recording branch are prefixed with this name
+const recordingName = "recording"
+
+// how long to wait for muxer to write remaining data when recording is stopped
+const recordingFinalizeTimeout = 5 * time.Second
+
+type recordingFormat struct {
+	muxer   string
+	plugins []string
+	codecs  map[string]string // codec name to parser inserted before muxer
+}
+
+var recordingFormats = map[string]recordingFormat{
+	"webm": {
+		muxer:   "webmmux",
+		plugins: []string{"matroska"},
+		codecs:  map[string]string{"vp8": "", "vp9": "", "vp9-svc": "", "opus": ""},
+	},
+	"mp4": {
+		muxer:   "mp4mux",
+		plugins: []string{"isomp4", "videoparsersbad"},
+		codecs:  map[string]string{"h264": "h264parse"},
+	},
+}
+
+// returns first format able to contain given codec
+func recordingFormatForCodec(codecName string) (string, bool) {
+	for _, name := range []string{"webm", "mp4"} {
+		if _, ok := recordingFormats[name].codecs[codecName]; ok {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// file of recording segment, first one is the path itself, next ones have the
+// segment number before extension, e.g. rec.1.webm
+func recordingSegmentPath(path string, segment int) string {
+	if segment == 0 {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), segment, ext)
+}
+
+// splits encoded stream before appsink with a tee and appends branch that muxes
+// it to a file, so that nothing is encoded twice
+func setPipelineRecording(pipelineStr string, appsinkName string, path string, format string, codecName string) (string, error) {
+	parser := recordingFormats[format].codecs[codecName]
+	if parser != "" {
+		parser += " ! "
+	}
+
+	elements := strings.Split(pipelineStr, "!")
+	for i, el := range elements {
+		fields := strings.Fields(el)
+		if len(fields) < 2 || fields[0] != "appsink" {
+			continue
+		}
+
+		if ok, _ := utils.ArrayIn("name="+appsinkName, fields[1:]); !ok {
+			continue
+		}
+
+		tee := fmt.Sprintf(" tee name=%s ", recordingName)
+		elements = append(elements[:i], append([]string{tee, " queue "}, elements[i:]...)...)
+
+		branch := fmt.Sprintf("%s. ! queue name=%squeue ! %s%s name=%smux ! filesink name=%ssink location='%s'",
+			recordingName, recordingName, parser, recordingFormats[format].muxer, recordingName, recordingName, path)
+		return strings.Join(elements, "!") + " " + branch, nil
+	}
+
+	return "", fmt.Errorf("%w: unable to find %s in pipeline", types.ErrPipelineParse, appsinkName)
+}
+
+// records encoded stream to a file, format is webm or mp4, empty to choose it
+// by codec. pipeline is kept running while recording even without listeners.
+// when the pipeline needs to be recreated, the file is finalized and recording
+// continues in next segment, see recordingSegmentPath.
+func (manager *StreamSinkManagerCtx) StartRecording(path string, format string) error {
+	if path == "" {
+		return errors.New("recording path must not be empty")
+	}
+
+	rtpCodec := manager.Codec()
+	if format == "" {
+		var ok bool
+		format, ok = recordingFormatForCodec(rtpCodec.Name)
+		if !ok {
+			return fmt.Errorf("unable to record %s stream", rtpCodec.Name)
+		}
+	}
+
+	recFormat, ok := recordingFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown recording format %s", format)
+	}
+
+	if _, ok := recFormat.codecs[rtpCodec.Name]; !ok {
+		return fmt.Errorf("unable to record %s stream as %s", rtpCodec.Name, format)
+	}
+
+	if err := manager.checkPluginsFn(recFormat.plugins); err != nil {
+		return err
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.recordingPath != "" {
+		return fmt.Errorf("already recording to %s", manager.recordingPath)
+	}
+
+	// fail early, so that stream is not recreated with unusable pipeline
+	if _, err := setPipelineRecording(manager.pipelineSrc(), manager.appsinkName(manager.layers[0]), path, format, manager.Codec().Name); err != nil {
+		return err
+	}
+
+	manager.recordingPath = path
+	manager.recordingFormat = format
+	manager.recordingError = nil
+	manager.recordingBytes = 0
+	manager.recordingFiles = nil
+	manager.logger().Info().Str("path", path).Str("format", format).Msgf("starting recording")
+
+	if manager.pipeline == nil {
+		return manager.buildPipeline()
+	}
+
+	// branch cannot be added to running pipeline
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+func (manager *StreamSinkManagerCtx) StopRecording() error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.recordingPath == "" {
+		return nil
+	}
+
+	if manager.pipeline == nil {
+		manager.recordingPath = ""
+		return nil
+	}
+
+	err := manager.finishRecording()
+
+	// pipeline was kept only because of recording
+	if !manager.active() {
+		manager.teardownPipeline()
+		return err
+	}
+
+	if rerr := manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	}); rerr != nil {
+		return rerr
+	}
+
+	return err
+}
+
+// ends recording, must be called with pipelineMu held and existing pipeline
+func (manager *StreamSinkManagerCtx) finishRecording() error {
+	if manager.recordingPath == "" {
+		return nil
+	}
+
+	err := manager.endRecordingSegment()
+	manager.logger().Info().
+		Str("path", manager.recordingPath).
+		Strs("files", manager.recordingFiles).
+		Msg("recording finished")
+
+	manager.recordingPath = ""
+	return err
+}
+
+// ends recording branch of the pipeline so that muxer finalizes the segment
+// file, recording itself is kept. must be called with pipelineMu held and
+// existing pipeline
+func (manager *StreamSinkManagerCtx) endRecordingSegment() error {
+	// pipeline created before recording was started has no branch
+	if !strings.Contains(manager.pipeline.Src(), "name="+recordingName+"sink") {
+		return nil
+	}
+
+	if bytes, ok := manager.pipeline.QueryBytes(recordingName + "sink"); ok {
+		manager.recordingBytes += bytes
+	}
+
+	file := manager.recordingFiles[len(manager.recordingFiles)-1]
+
+	err := manager.pipeline.EndBranch(recordingName+"queue", recordingName+"sink", recordingFinalizeTimeout)
+	if err != nil {
+		manager.logger().Warn().Err(err).Str("file", file).Msg("unable to finalize recording segment")
+		manager.recordingError = err
+	} else {
+		manager.logger().Info().Str("file", file).Msg("recording segment finalized")
+	}
+
+	return err
+}
+
+// when enabled, timestamps of recordings are shifted so that files start at
+// zero, otherwise they keep the pipeline timing and may begin with an empty
+// lead-in. live stream timing is not changed. applied to next recording.
+func (manager *StreamSinkManagerCtx) SetRecordingRebase(enabled bool) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.recordingRebase = enabled
+}
+
+func (manager *StreamSinkManagerCtx) Recording() bool {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.recordingPath != ""
+}
+
+// bytes written and files are of the current recording, or of the last one
+// when stopped
+func (manager *StreamSinkManagerCtx) RecordingStatus() types.StreamRecordingStatus {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	status := types.StreamRecordingStatus{
+		Active:       manager.recordingPath != "",
+		Path:         manager.recordingPath,
+		Format:       manager.recordingFormat,
+		Files:        append([]string(nil), manager.recordingFiles...),
+		BytesWritten: manager.recordingBytes,
+		LastError:    manager.recordingError,
+	}
+
+	if status.Active && manager.pipeline != nil && strings.Contains(manager.pipeline.Src(), "name="+recordingName+"sink") {
+		status.Running = true
+		if bytes, ok := manager.pipeline.QueryBytes(recordingName + "sink"); ok {
+			status.BytesWritten += bytes
+		}
+	}
+
+	return status
+}
@@ -1,110 +1,692 @@
 package capture
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 
 	"m1k1o/neko/internal/capture/gst"
 	"m1k1o/neko/internal/types"
 	"m1k1o/neko/internal/types/codec"
 )
 
+const (
+	// default size of sample buffer between pipeline and emit goroutine
+	defaultSampleBufferSize = 100
+	// size of per subscriber sample buffer, samples are dropped when it is full
+	subscriberBufferSize = 100
+
+	// warn about dropped samples at most once per interval and only above threshold
+	droppedSamplesWarnThreshold = 50
+	droppedSamplesWarnInterval  = 10 * time.Second
+
+	// backoff between attempts to recreate a failed pipeline
+	restartBackoffMin = 500 * time.Millisecond
+	restartBackoffMax = 30 * time.Second
+
+	// how long shutdown waits for emit and watchdog goroutines to exit
+	shutdownTimeout = 5 * time.Second
+)
+
+// current tunables passed to pipelineFn, zero values mean not set
+type PipelineParams struct {
+	Codec       codec.RTPCodec
+	Framerate   int16
+	Bitrate     int // kbit/s
+	Width       int
+	Height      int
+	Display     string // X display of video source
+	AudioSource string // pulseaudio device of audio source
+}
+
+type streamSubscriber struct {
+	samples chan types.Sample
+	batch   *sampleBatch // set instead of samples for batch subscribers
+	layer   string
+
+	deliveredSamples atomic.Uint64
+	droppedSamples   atomic.Uint64
+	deliveredBytes   atomic.Uint64
+}
+
+// locks are always taken in order mu or adaptiveMu, pipelineMu, then
+// listenersMu or subscribersMu. mu serializes listener changes with decisions to create or
+// destroy the pipeline based on them, so that every change of listeners count
+// from and to zero is followed by exactly one create or destroy. pipelineMu
+// guards the pipeline and its tunables, it is also taken alone by restarts
+// and reconfiguration which never change whether the pipeline is needed.
 type StreamSinkManagerCtx struct {
-	logger        zerolog.Logger
-	mu            sync.Mutex
-	sampleChannel chan types.Sample
+	id     string
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// logger with level of the manager is swapped, see SetLogLevel
+	baseLogger    zerolog.Logger
+	currentLogger atomic.Pointer[zerolog.Logger]
+
+	// one appsink and sample channel per quality layer, first one is default
+	layers         []string
+	sampleChannels map[string]chan types.Sample
+	appsinkNames   map[string]string // overrides of default names, guarded by pipelineMu
+
+	// bytes emitted by each layer, map itself is not changed after creation
+	layerBytes map[string]*atomic.Uint64
+
+	subscribers   map[string]*streamSubscriber
+	subscribersMu sync.RWMutex
+	// so that emit does not look for batches when there are none
+	batchSubscribers atomic.Int32
+
+	// closed when current pipeline emits its first sample
+	firstSample     chan struct{}
+	firstSampleSeen atomic.Bool
+	firstSampleMu   sync.Mutex
+
+	// incremented when pipeline is created, so that emit can count its frames
+	pipelineGeneration atomic.Uint64
 
-	codec      codec.RTPCodec
-	pipeline   *gst.Pipeline
+	lastSampleAt   atomic.Int64 // unix nano
+	droppedSamples atomic.Uint64
+	keyframes      atomic.Uint64
+	frameStats     frameStats
+	bitrateStats   bitrateStats
+	joinLatency    joinLatency
+	emittedSamples atomic.Uint64
+	emittedBytes   atomic.Uint64
+
+	// drop oldest buffered sample instead of newest, guarded by pipelineMu
+	latestOnly bool
+	// samples reference pipeline memory instead of copies, guarded by pipelineMu
+	leaseBuffers bool
+
+	// changed only while there is no pipeline, read without lock by emit
+	codec      atomic.Pointer[codec.RTPCodec]
+	pipeline   types.Pipeline
 	pipelineMu sync.Mutex
-	pipelineFn func() (string, error)
+	pipelineFn func(params PipelineParams) (string, error)
+	// tried in order when pipeline of pipelineFn cannot be created, e.g. software
+	// encoders when hardware ones fail to initialize
+	pipelineFallbackFns []func(params PipelineParams) (string, error)
+	// builds pipeline as element graph instead of pipelineFn if set
+	pipelineGraphFn func(params PipelineParams) (*PipelineGraph, error)
+	// creates pipeline from string, replaceable so that manager can be used without gstreamer
+	createPipelineFn func(pipelineStr string) (types.Pipeline, error)
+	// checks plugins needed by added branches, replaceable together with createPipelineFn
+	checkPluginsFn func(plugins []string) error
+
+	// runtime tunables guarded by pipelineMu, they are passed to pipelineFn and
+	// also applied to the pipeline string it returns, zero means not set
+	bitrate           int
+	changeFramerate   int16
+	adaptiveFramerate bool
+	minFramerate      int16 // bounds changeFramerate is clamped to, zero is unbounded
+	maxFramerate      int16
+	width             int
+	height            int
+	rateControl       types.RateControlMode
+	encoderPreset     string
+	intraRefresh      bool
+	keyframeInterval  int    // in frames
+	transform         string // videoflip method
+	qpMin             int
+	qpMax             int // zero means quantizer is not set
+	queueLeaky        string
+	queueMaxBuffers   int
+	cursorSet         bool // cursorVisible overrides pipeline only if set
+	cursorVisible     bool
+	region            *captureRegion
+	display           string // overrides display of pipelineFn if set
+	audioSource       string // overrides audio device of pipelineFn if set
+
+	// RTMP or SRT url the capture is pushed to, empty when not broadcasting, guarded by pipelineMu
+	broadcastUrl      string
+	broadcastProtocol string
+	broadcastError    error
+	// set when pipeline with broadcast reaches playing, cleared by broadcast error
+	broadcastConnected bool
+	// kbit/s of broadcast encoder, zero for its default
+	broadcastBitrateCap int
+
+	// file the encoded stream is recorded to, empty when not recording, guarded by pipelineMu
+	recordingPath   string
+	recordingFormat string
+	recordingBytes  int64 // of finished segments
+	recordingFiles  []string
+	recordingError  error
+	recordingRebase bool // recording timestamps start at zero
+
+	// directory hls segments are written to, empty when not active, guarded by pipelineMu
+	hlsDir             string
+	hlsSegmentDuration time.Duration
+	// raw audio source muxed into hls segments, empty for video only
+	hlsAudioSrc string
+
+	// sources captured also by pipelines of other managers, set before start
+	sharedSources []*sharedSource
+	// sources the current pipeline holds, guarded by pipelineMu
+	acquiredSources []*sharedSource
+
+	// bandwidth driven adaptation, last applied values are kept to debounce changes
+	adaptiveMu             sync.Mutex
+	adaptiveLimits         adaptiveLimits
+	adaptiveParams         adaptiveParams
+	adaptiveBitrate        int
+	adaptiveFramerateValue int16
+	adaptiveChangedAt      time.Time
+
+	// pause pipeline instead of destroying it when last listener leaves
+	pauseOnIdle bool
+	paused      bool
+	// listener is being added, pipeline must not be paused as idle meanwhile
+	starting bool
+	// pipeline is kept running without listeners until shutdown, guarded by pipelineMu
+	alwaysOn bool
+
+	// pipeline is kept without listeners until cooldown, guarded by pipelineMu
+	prewarmed    bool
+	prewarmTimer *time.Timer
+
+	lastError       error // guarded by pipelineMu
+	restartAttempts int
+
+	// guarded by pipelineMu
+	pipelineCreatedAt time.Time
+	lastRestartAt     time.Time
+	restartCount      int
+
+	screenshotJPEG   screenshotCache
+	screenshotPNG    screenshotCache
+	screenshotFrame  screenshotFrame
+	screenshotMaxAge atomic.Int64
+
+	listeners         int
+	maxListeners      int // zero means unlimited
+	listenersMu       sync.Mutex
+	listenersHandlers []func(count int)
+
+	// called for every sample in emit goroutine, slice is replaced, never changed
+	sampleHooks atomic.Pointer[[]func(types.Sample) (types.Sample, bool)]
+
+	playingHandlers   []func()
+	playingHandlersMu sync.Mutex
 
-	listeners   int
-	listenersMu sync.Mutex
+	// sends black frames until pipeline emits its first sample, guarded by pipelineMu
+	placeholder         bool
+	placeholderPipeline types.Pipeline
+	placeholderActive   atomic.Bool // so that emit does not lock to check it
+
+	// appsinks receive rtp packets instead of encoded frames, guarded by pipelineMu
+	rtpOutput bool
+	// rtp output of current pipeline, so that emit does not lock to check it
+	rtpOutputActive atomic.Bool
+
+	// result of last health check, returned when pipelineMu is held
+	healthy atomic.Bool
+	// current pipeline reported error other than of broadcast, guarded by pipelineMu
+	pipelineErrored bool
+	// how long started pipeline may not produce samples, zero disables the check
+	stallTimeout time.Duration
+
+	// pipeline lifecycle events, see Events
+	events   chan types.PipelineEvent
+	eventsMu sync.Mutex
+
+	// returns size of captured display, used to validate region and detect resizing
+	displaySizeFn func() (width, height int)
+	// display size the pipeline was created for, guarded by pipelineMu
+	sourceWidth  int
+	sourceHeight int
+	// checks that display can be captured, used to validate display override
+	displayExistsFn func(display string) bool
+	// checks that audio source can be captured, used to validate audio source override
+	audioSourceExistsFn func(name string) bool
 }
 
-func streamSinkNew(codec codec.RTPCodec, pipelineFn func() (string, error), video_id string) *StreamSinkManagerCtx {
-	logger := log.With().
+// layers are names of quality layers produced by pipelineFn, each must have its own
+// appsink named appsinkvideo_<layer> (or audio), empty for single unnamed layer.
+// sampleBufferSize is size of buffer between pipeline and subscribers per layer,
+// smaller buffer means lower latency and memory use, but samples are dropped
+// sooner when subscribers are slow, zero for default. stallTimeout is how long
+// pipeline with listeners may not produce samples before it is considered
+// stalled, zero disables the watchdog. logger is the base of manager logs, its
+// level is the initial level of the manager, see SetLogLevel.
+func streamSinkNew(codec codec.RTPCodec, pipelineFn func(params PipelineParams) (string, error), video_id string, restartAttempts int, layers []string, sampleBufferSize int, stallTimeout time.Duration, logger zerolog.Logger) *StreamSinkManagerCtx {
+	if len(layers) == 0 {
+		layers = []string{""}
+	}
+
+	if sampleBufferSize <= 0 {
+		sampleBufferSize = defaultSampleBufferSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	manager := &StreamSinkManagerCtx{
+		id:             video_id,
+		ctx:            ctx,
+		cancel:         cancel,
+		pipelineFn:     pipelineFn,
+		layers:         layers,
+		sampleChannels: map[string]chan types.Sample{},
+		layerBytes:     map[string]*atomic.Uint64{},
+		appsinkNames:   map[string]string{},
+		subscribers:    map[string]*streamSubscriber{},
+		firstSample:    make(chan struct{}),
+		events:         make(chan types.PipelineEvent, eventsBufferSize),
+
+		restartAttempts:  restartAttempts,
+		stallTimeout:     stallTimeout,
+		createPipelineFn: createGstPipeline,
+		checkPluginsFn:   gst.CheckPlugins,
+
+		// late video frames are useless, but audio gaps are audible
+		latestOnly: codec.IsVideo(),
+	}
+
+	manager.codec.Store(&codec)
+	manager.healthy.Store(true)
+	manager.screenshotMaxAge.Store(int64(defaultScreenshotMaxAge))
+	manager.baseLogger = logger.With().
 		Str("module", "capture").
 		Str("submodule", "stream-sink").
 		Str("video_id", video_id).Logger()
+	manager.currentLogger.Store(&manager.baseLogger)
 
-	manager := &StreamSinkManagerCtx{
-		logger:        logger,
-		codec:         codec,
-		pipelineFn:    pipelineFn,
-		sampleChannel: make(chan types.Sample),
+	for _, layer := range layers {
+		samples := make(chan types.Sample, sampleBufferSize)
+		manager.sampleChannels[layer] = samples
+		manager.layerBytes[layer] = &atomic.Uint64{}
+
+		manager.wg.Add(1)
+		go func(layer string) {
+			defer manager.wg.Done()
+			manager.emit(layer, samples)
+		}(layer)
+	}
+
+	if stallTimeout > 0 {
+		manager.wg.Add(1)
+		go func() {
+			defer manager.wg.Done()
+			manager.watchdog(stallTimeout)
+		}()
 	}
 
 	return manager
 }
 
+func createGstPipeline(pipelineStr string) (types.Pipeline, error) {
+	pipeline, err := gst.CreatePipeline(pipelineStr)
+	if err != nil {
+		// avoid returning non-nil interface holding nil pointer
+		return nil, err
+	}
+
+	return pipeline, nil
+}
+
 func (manager *StreamSinkManagerCtx) shutdown() {
-	manager.logger.Info().Msgf("shutdown")
+	manager.logger().Info().Msgf("shutdown")
+
+	manager.pipelineMu.Lock()
+	manager.stopPrewarm()
+	if manager.pipeline != nil {
+		_ = manager.finishRecording()
+	}
+	manager.pipelineMu.Unlock()
 
 	manager.destroyPipeline()
+
+	// stop emitting and release all subscribers
+	manager.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		manager.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		manager.logger().Error().Msgf("goroutines did not exit in %s, proceeding with shutdown", shutdownTimeout)
+	}
+
+	manager.subscribersMu.Lock()
+	for id, subscriber := range manager.subscribers {
+		delete(manager.subscribers, id)
+		manager.closeSubscriber(subscriber)
+	}
+	manager.subscribersMu.Unlock()
 }
 
 func (manager *StreamSinkManagerCtx) Codec() codec.RTPCodec {
-	return manager.codec
+	return *manager.codec.Load()
+}
+
+func (manager *StreamSinkManagerCtx) ClockRate() uint32 {
+	return manager.Codec().Capability.ClockRate
+}
+
+func (manager *StreamSinkManagerCtx) PayloadType() uint8 {
+	return uint8(manager.Codec().PayloadType)
+}
+
+// returns timebase of rtp timestamps as fraction of second, sample timestamps
+// and durations are in nanoseconds and are converted to it when packetized
+func (manager *StreamSinkManagerCtx) Timebase() (numerator uint32, denominator uint32) {
+	return 1, manager.ClockRate()
+}
+
+// replaces codec and pipeline builder of the stream, allowed only while there
+// are no listeners, so that codec never changes mid-stream. idle pipeline is
+// destroyed, subscribers are kept. tunables are kept, except encoder tunables
+// the new pipeline does not support. graph builder and fallbacks are unset.
+func (manager *StreamSinkManagerCtx) SetCodec(codec codec.RTPCodec, pipelineFn func(params PipelineParams) (string, error)) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if count := manager.ListenersCount(); count > 0 {
+		return fmt.Errorf("unable to change codec to %s while stream has %d listeners", codec.Name, count)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if codec.Type != manager.Codec().Type {
+		return fmt.Errorf("unable to change codec of %s stream to %s", manager.Codec().Name, codec.Name)
+	}
+
+	if manager.broadcastUrl != "" || manager.recordingPath != "" {
+		return fmt.Errorf("unable to change codec to %s while broadcasting or recording", codec.Name)
+	}
+
+	if manager.pipeline != nil {
+		manager.teardownPipeline()
+	}
+
+	manager.logger().Info().
+		Str("from", manager.Codec().Name).
+		Str("to", codec.Name).
+		Msgf("changing codec")
+
+	manager.codec.Store(&codec)
+	manager.pipelineFn = pipelineFn
+	manager.pipelineGraphFn = nil
+	manager.pipelineFallbackFns = nil
+	manager.lastError = nil
+	manager.clearUnsupportedTunables()
+	return nil
+}
+
+// clears encoder tunables the encoder of current pipeline builder does not
+// support, so that its pipeline can be created. must be called with pipelineMu
+// held and without pipeline.
+func (manager *StreamSinkManagerCtx) clearUnsupportedTunables() {
+	pipelineStr := manager.pipelineSrc()
+	if pipelineStr == "" {
+		return
+	}
+
+	if manager.rateControl != "" {
+		if _, _, _, err := pipelineRateControlProp(pipelineStr, manager.rateControl); err != nil {
+			manager.logger().Warn().Err(err).Str("mode", string(manager.rateControl)).Msg("clearing unsupported rate control")
+			manager.rateControl = ""
+		}
+	}
+
+	if manager.encoderPreset != "" {
+		if _, err := pipelineEncoderPresetProp(pipelineStr, manager.encoderPreset); err != nil {
+			manager.logger().Warn().Err(err).Str("preset", manager.encoderPreset).Msg("clearing unsupported encoder preset")
+			manager.encoderPreset = ""
+		}
+	}
+
+	if manager.intraRefresh {
+		if _, err := pipelineIntraRefreshProp(pipelineStr); err != nil {
+			manager.logger().Warn().Err(err).Msg("clearing unsupported intra refresh")
+			manager.intraRefresh = false
+		}
+	}
+
+	if manager.keyframeInterval > 0 {
+		if _, _, err := pipelineKeyframeIntervalProp(pipelineStr); err != nil {
+			manager.logger().Warn().Err(err).Int("frames", manager.keyframeInterval).Msg("clearing unsupported keyframe interval")
+			manager.keyframeInterval = 0
+		}
+	}
+
+	if manager.qpMax > 0 {
+		if _, err := pipelineQPProps(pipelineStr, manager.qpMin, manager.qpMax); err != nil {
+			manager.logger().Warn().Err(err).Int("min", manager.qpMin).Int("max", manager.qpMax).Msg("clearing unsupported qp range")
+			manager.qpMin = 0
+			manager.qpMax = 0
+		}
+	}
+}
+
+// builds pipelines from element graph instead of pipeline string, so that
+// tunables are applied to its elements directly. nil returns to pipelineFn.
+// running pipeline is recreated.
+func (manager *StreamSinkManagerCtx) SetPipelineGraphFn(pipelineGraphFn func(params PipelineParams) (*PipelineGraph, error)) error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.pipelineGraphFn = pipelineGraphFn
+
+	if manager.pipeline == nil {
+		return nil
+	}
+
+	manager.logger().Info().Bool("graph", pipelineGraphFn != nil).Msgf("changing pipeline builder")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+// sets pipeline builders tried in order when pipeline of pipelineFn fails to be
+// created, e.g. because hardware encoder driver is missing at runtime. they are
+// not used with graph builder. nil removes them.
+func (manager *StreamSinkManagerCtx) SetPipelineFallbacks(pipelineFns ...func(params PipelineParams) (string, error)) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.pipelineFallbackFns = pipelineFns
 }
 
+// must be called with mu held, before listener is added. prewarmed or paused
+// pipeline is resumed instead of being created.
 func (manager *StreamSinkManagerCtx) start() error {
-	if manager.listeners == 0 {
+	if manager.ListenersCount() == 0 {
 		err := manager.createPipeline()
 		if err != nil && !errors.Is(err, types.ErrCapturePipelineAlreadyExists) {
 			return err
 		}
 
-		manager.logger.Info().Msgf("first listener, starting")
+		// pipeline may have been paused by last listener or prewarm
+		if err := manager.Resume(); err != nil {
+			return err
+		}
+
+		manager.logger().Info().Msgf("first listener, starting")
 	}
 
 	return nil
 }
 
+// must be called with mu held, after listener is removed. prewarmed pipeline
+// is paused instead of destroyed, it is destroyed only after cooldown.
 func (manager *StreamSinkManagerCtx) stop() {
-	if manager.listeners == 0 {
+	// pipeline is still needed for broadcast or recording
+	if manager.ListenersCount() == 0 && !manager.inUse() {
+		manager.pipelineMu.Lock()
+		pauseOnIdle := manager.pauseOnIdle || manager.prewarmed
+		manager.pipelineMu.Unlock()
+
+		if pauseOnIdle {
+			if err := manager.Pause(); err == nil {
+				manager.logger().Info().Msgf("last listener, pausing")
+				return
+			}
+
+			manager.logger().Warn().Msgf("unable to pause pipeline, destroying it")
+		}
+
 		manager.destroyPipeline()
-		manager.logger.Info().Msgf("last listener, stopping")
+		manager.logger().Info().Msgf("last listener, stopping")
 	}
 }
 
 func (manager *StreamSinkManagerCtx) addListener() {
 	manager.listenersMu.Lock()
 	manager.listeners++
+	count := manager.listeners
+	handlers := manager.listenersHandlers
 	manager.listenersMu.Unlock()
+
+	manager.emitListenersChange(handlers, count)
 }
 
-func (manager *StreamSinkManagerCtx) removeListener() {
+// returns false if there is no listener to remove, count never goes below zero
+func (manager *StreamSinkManagerCtx) removeListener() bool {
 	manager.listenersMu.Lock()
+	if manager.listeners <= 0 {
+		manager.listenersMu.Unlock()
+		return false
+	}
+
 	manager.listeners--
+	count := manager.listeners
+	handlers := manager.listenersHandlers
 	manager.listenersMu.Unlock()
+
+	manager.emitListenersChange(handlers, count)
+	return true
+}
+
+// handlers are called without listenersMu held, so they can query the manager,
+// transitions are serialized by mu held in AddListener and RemoveListener
+func (manager *StreamSinkManagerCtx) emitListenersChange(handlers []func(count int), count int) {
+	for _, handler := range handlers {
+		handler(count)
+	}
+}
+
+// registers hook that sees every sample before it is passed to subscribers and
+// returns it, possibly modified, or false to drop it. hooks run in registration
+// order on the hot path of every sample, so they must be fast and must not block.
+// sample references pipeline memory if buffer leasing is enabled, hook must not
+// keep its data.
+func (manager *StreamSinkManagerCtx) RegisterSampleHook(hook func(types.Sample) (types.Sample, bool)) {
+	manager.listenersMu.Lock()
+	defer manager.listenersMu.Unlock()
+
+	var hooks []func(types.Sample) (types.Sample, bool)
+	if current := manager.sampleHooks.Load(); current != nil {
+		hooks = append(hooks, *current...)
+	}
+
+	hooks = append(hooks, hook)
+	manager.sampleHooks.Store(&hooks)
+}
+
+func runSampleHooks(hooks []func(types.Sample) (types.Sample, bool), sample types.Sample) (types.Sample, bool) {
+	for _, hook := range hooks {
+		var keep bool
+		if sample, keep = hook(sample); !keep {
+			return sample, false
+		}
+	}
+
+	return sample, true
+}
+
+// registers handler called with new listeners count on every change, count 1
+// after adding means stream started and count 0 after removing means it stopped
+func (manager *StreamSinkManagerCtx) OnListenerChange(handler func(count int)) {
+	manager.listenersMu.Lock()
+	defer manager.listenersMu.Unlock()
+
+	manager.listenersHandlers = append(manager.listenersHandlers, handler)
 }
 
 func (manager *StreamSinkManagerCtx) AddListener() error {
+	return manager.AddListenerCtx(context.Background())
+}
+
+// adds listener unless ctx is done, pipeline started for a caller that gave up
+// meanwhile is stopped again and listener is not added
+func (manager *StreamSinkManagerCtx) AddListenerCtx(ctx context.Context) error {
+	// join latency includes waiting for other listeners and pipeline startup
+	joinedAt := time.Now()
+
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
+	// caller may have given up while waiting for other listeners
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// refuse before pipeline is started, so that nothing is changed
+	manager.listenersMu.Lock()
+	full := manager.maxListeners > 0 && manager.listeners >= manager.maxListeners
+	manager.listenersMu.Unlock()
+
+	if full {
+		return types.ErrTooManyListeners
+	}
+
+	manager.setStarting(true)
+	defer manager.setStarting(false)
+
 	// start if stopped
 	if err := manager.start(); err != nil {
 		return err
 	}
 
+	if err := ctx.Err(); err != nil {
+		manager.stop()
+		return err
+	}
+
 	// add listener
 	manager.addListener()
+	manager.joinLatency.join(joinedAt)
 
 	return nil
 }
 
+func (manager *StreamSinkManagerCtx) setStarting(starting bool) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.starting = starting
+}
+
 func (manager *StreamSinkManagerCtx) RemoveListener() error {
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
-	// remove listener
-	manager.removeListener()
+	// remove listener, e.g. double disconnect must not stop stream of others
+	if !manager.removeListener() {
+		manager.logger().Warn().Msgf("removing listener that was not added, ignoring")
+		return nil
+	}
+
+	// nobody is left to receive the first sample
+	if manager.ListenersCount() == 0 {
+		manager.joinLatency.reset()
+	}
 
 	// stop if started
 	manager.stop()
@@ -112,6 +694,15 @@ func (manager *StreamSinkManagerCtx) RemoveListener() error {
 	return nil
 }
 
+// limits concurrent listeners, zero means unlimited. listeners above the new
+// limit are kept, only new ones are refused.
+func (manager *StreamSinkManagerCtx) SetMaxListeners(n int) {
+	manager.listenersMu.Lock()
+	defer manager.listenersMu.Unlock()
+
+	manager.maxListeners = n
+}
+
 func (manager *StreamSinkManagerCtx) ListenersCount() int {
 	manager.listenersMu.Lock()
 	defer manager.listenersMu.Unlock()
@@ -131,45 +722,1844 @@ func (manager *StreamSinkManagerCtx) createPipeline() error {
 		return types.ErrCapturePipelineAlreadyExists
 	}
 
-	pipelineStr, err := manager.pipelineFn()
+	return manager.buildPipeline()
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) buildPipeline() (err error) {
+	defer func() {
+		if err != nil {
+			manager.lastError = err
+		}
+	}()
+
+	// display may have been removed since it was set
+	if manager.display != "" && manager.displayExistsFn != nil && !manager.displayExistsFn(manager.display) {
+		return fmt.Errorf("%w: display %s does not exist", types.ErrPipelineResource, manager.display)
+	}
+
+	// the same for audio source
+	if manager.audioSource != "" && manager.audioSourceExistsFn != nil && !manager.audioSourceExistsFn(manager.audioSource) {
+		return fmt.Errorf("%w: audio source %s does not exist", types.ErrPipelineResource, manager.audioSource)
+	}
+
+	if manager.pipelineGraphFn != nil {
+		return manager.buildPipelineGraph()
+	}
+
+	pipelineFns := append([]func(params PipelineParams) (string, error){manager.pipelineFn}, manager.pipelineFallbackFns...)
+	for i, pipelineFn := range pipelineFns {
+		err = manager.buildPipelineFn(pipelineFn)
+		if err == nil {
+			if i > 0 {
+				manager.logger().Warn().Int("fallback", i).Msgf("pipeline created using fallback")
+			}
+			return nil
+		}
+
+		if i < len(pipelineFns)-1 {
+			manager.logger().Warn().Err(err).Int("fallback", i+1).Msgf("unable to create pipeline, trying fallback")
+		}
+	}
+
+	return err
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) buildPipelineFn(pipelineFn func(params PipelineParams) (string, error)) error {
+	pipelineStr, err := pipelineFn(manager.pipelineParams())
 	if err != nil {
 		return err
 	}
 
-	manager.logger.Info().
-		Str("codec", manager.codec.Name).
-		Str("src", pipelineStr).
-		Msgf("creating pipeline")
+	if err := validatePipelineNotEmpty(pipelineStr); err != nil {
+		return err
+	}
 
-	manager.pipeline, err = gst.CreatePipeline(pipelineStr)
-	if err != nil {
+	if err := manager.validateCodec(pipelineStr); err != nil {
 		return err
 	}
 
-	appsinkSubfix := "audio"
-	if manager.codec.IsVideo() {
-		appsinkSubfix = "video"
+	tunable := &pipelineString{pipelineStr}
+	if err := manager.applyTunables(tunable); err != nil {
+		return err
 	}
 
-	manager.pipeline.AttachAppsink("appsink"+appsinkSubfix, manager.sampleChannel)
-	manager.pipeline.Play()
+	// rewrites, e.g. of framerate, must not leave the pipeline broken
+	if err := validatePipelineNotEmpty(tunable.src); err != nil {
+		return err
+	}
 
-	return nil
+	return manager.finishPipeline(tunable.src)
 }
 
-func (manager *StreamSinkManagerCtx) destroyPipeline() {
-	manager.pipelineMu.Lock()
-	defer manager.pipelineMu.Unlock()
+// applies tunables to the graph instead of rewriting pipeline string,
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) buildPipelineGraph() error {
+	graph, err := manager.pipelineGraphFn(manager.pipelineParams())
+	if err != nil {
+		return err
+	}
 
-	if manager.pipeline == nil {
-		return
+	if err := manager.validateCodec(graph.String()); err != nil {
+		return err
 	}
 
-	manager.pipeline.Destroy()
-	manager.logger.Info().Msgf("destroying pipeline")
-	manager.pipeline = nil
+	if err := manager.applyTunables(graph); err != nil {
+		return err
+	}
+
+	return manager.finishPipeline(graph.String())
+}
+
+// encoder and scaling settings that survive pipeline being recreated, implemented
+// by both pipeline graph and pipeline string
+type pipelineTunables interface {
+	SetBitrate(kbps int) error
+	SetRateControl(mode types.RateControlMode) error
+	SetEncoderPreset(preset string) error
+	SetIntraRefresh() error
+	SetKeyframeInterval(frames int) error
+	SetTransform(method string) error
+	SetQP(min, max int) error
+	SetResolution(width, height int) error
+	SetFramerate(framerate int16) bool
+	SetQueues(leaky string, maxBuffers int) bool
 }
 
-func (manager *StreamSinkManagerCtx) GetSampleChannel() chan types.Sample {
-	return manager.sampleChannel
+// applies all tunables set on manager, every new pipeline goes through it so that
+// nothing is lost when pipeline is destroyed and created again. must be called
+// with pipelineMu held
+func (manager *StreamSinkManagerCtx) applyTunables(pipeline pipelineTunables) error {
+	if manager.bitrate > 0 {
+		if err := pipeline.SetBitrate(manager.bitrate); err != nil {
+			return err
+		}
+	}
+
+	if manager.rateControl != "" {
+		if err := pipeline.SetRateControl(manager.rateControl); err != nil {
+			return err
+		}
+	}
+
+	if manager.encoderPreset != "" {
+		if err := pipeline.SetEncoderPreset(manager.encoderPreset); err != nil {
+			return err
+		}
+	}
+
+	if manager.intraRefresh {
+		if err := pipeline.SetIntraRefresh(); err != nil {
+			return err
+		}
+	}
+
+	if manager.keyframeInterval > 0 {
+		if err := pipeline.SetKeyframeInterval(manager.keyframeInterval); err != nil {
+			return err
+		}
+	}
+
+	if manager.qpMax > 0 {
+		if err := pipeline.SetQP(manager.qpMin, manager.qpMax); err != nil {
+			return err
+		}
+	}
+
+	if manager.transform != "" {
+		if err := pipeline.SetTransform(manager.transform); err != nil {
+			return err
+		}
+	}
+
+	if manager.width > 0 && manager.height > 0 {
+		if err := pipeline.SetResolution(manager.width, manager.height); err != nil {
+			return err
+		}
+	}
+
+	if manager.adaptiveFramerate && manager.changeFramerate > 0 && !pipeline.SetFramerate(manager.changeFramerate) {
+		manager.logger().Warn().
+			Int16("framerate", manager.changeFramerate).
+			Msgf("unable to find framerate in pipeline, it will not be changed")
+	}
+
+	if (manager.queueLeaky != "" || manager.queueMaxBuffers > 0) && !pipeline.SetQueues(manager.queueLeaky, manager.queueMaxBuffers) {
+		manager.logger().Warn().Msgf("unable to find queues in pipeline, they will not be changed")
+	}
+
+	return nil
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) validateCodec(pipelineStr string) error {
+	known, err := validatePipelineCodec(pipelineStr, manager.Codec().Name)
+	if err != nil {
+		return err
+	}
+
+	if !known {
+		manager.logger().Warn().
+			Str("codec", manager.Codec().Name).
+			Msgf("unable to find known encoder in pipeline, codec compatibility cannot be verified")
+	}
+
+	return nil
+}
+
+// adds source overrides and branches to pipeline string and creates the pipeline,
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) finishPipeline(pipelineStr string) (err error) {
+	// placeholder has only the main chain without source overrides and branches
+	mainStr := pipelineStr
+
+	if manager.region != nil {
+		r := manager.region
+		pipelineStr, err = setPipelineRegion(pipelineStr, r.x, r.y, r.width, r.height)
+		if err != nil {
+			return err
+		}
+	}
+
+	if manager.cursorSet {
+		pipelineStr, err = setPipelineCursor(pipelineStr, manager.cursorVisible)
+		if err != nil {
+			return err
+		}
+	}
+
+	if manager.broadcastUrl != "" {
+		pipelineStr, err = setPipelineBroadcast(pipelineStr, manager.broadcastProtocol, manager.broadcastUrl, manager.broadcastBitrateCap)
+		if err != nil {
+			return err
+		}
+	}
+
+	if manager.hlsDir != "" {
+		pipelineStr, err = setPipelineHLS(pipelineStr, manager.hlsDir, manager.hlsSegmentDuration, pipelineFramerate(pipelineStr), manager.hlsAudioSrc)
+		if err != nil {
+			return err
+		}
+	}
+
+	recordingFile := ""
+	if manager.recordingPath != "" {
+		recordingFile = recordingSegmentPath(manager.recordingPath, len(manager.recordingFiles))
+		pipelineStr, err = setPipelineRecording(pipelineStr, manager.appsinkName(manager.layers[0]), recordingFile, manager.recordingFormat, manager.Codec().Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	// payloader must be right before appsinks, after branches splitting encoded stream
+	if manager.rtpOutput {
+		appsinkNames := make([]string, len(manager.layers))
+		for i, layer := range manager.layers {
+			appsinkNames[i] = manager.appsinkName(layer)
+		}
+
+		pipelineStr, err = setPipelineRTPOutput(pipelineStr, appsinkNames, manager.Codec().Name, uint8(manager.Codec().PayloadType))
+		if err != nil {
+			return err
+		}
+
+		mainStr, err = setPipelineRTPOutput(mainStr, appsinkNames, manager.Codec().Name, uint8(manager.Codec().PayloadType))
+		if err != nil {
+			return err
+		}
+	}
+
+	// screenshots are taken from frames of running pipeline
+	if manager.Codec().IsVideo() && len(manager.layers) == 1 {
+		if str, err := setPipelineScreenshot(pipelineStr); err == nil {
+			pipelineStr = str
+		} else {
+			manager.logger().Debug().Err(err).Msg("screenshots will use transient pipeline")
+		}
+	}
+
+	var acquired []*sharedSource
+	for _, source := range manager.sharedSources {
+		var ok bool
+		pipelineStr, ok, err = source.acquire(manager, pipelineStr)
+		if err != nil {
+			go manager.releaseSharedSources()
+			return err
+		}
+		if ok {
+			acquired = append(acquired, source)
+		}
+	}
+
+	manager.logger().Info().
+		Str("codec", manager.Codec().Name).
+		Str("src", pipelineStr).
+		Msgf("creating pipeline")
+
+	pipeline, err := manager.createPipelineFn(pipelineStr)
+	if err != nil {
+		go manager.releaseSharedSources()
+		return err
+	}
+
+	// pipeline is kept only if it was fully set up
+	if err := manager.setupPipeline(pipeline); err != nil {
+		pipeline.Destroy()
+		go manager.releaseSharedSources()
+		return err
+	}
+
+	if recordingFile != "" {
+		manager.recordingFiles = append(manager.recordingFiles, recordingFile)
+	}
+
+	manager.pipeline = pipeline
+	manager.acquiredSources = acquired
+	manager.pipelineCreatedAt = time.Now()
+	manager.pipelineErrored = false
+	manager.rtpOutputActive.Store(manager.rtpOutput)
+	manager.updateSourceSize()
+	manager.resetFirstSample()
+	manager.pipelineGeneration.Add(1)
+	manager.emitEvent(types.PipelineEventCreated, nil)
+	go manager.watchErrors(pipeline)
+	go manager.watchPlaying(pipeline)
+
+	if manager.placeholder {
+		manager.startPlaceholder(mainStr)
+	}
+
+	// recreated idle pipeline without listeners must stay paused
+	return manager.pauseIdle()
+}
+
+func (manager *StreamSinkManagerCtx) setupPipeline(pipeline types.Pipeline) error {
+	pipeline.SetLatestOnly(manager.latestOnly)
+	pipeline.SetLeaseBuffers(manager.leaseBuffers)
+	pipeline.SetDroppedCounter(&manager.droppedSamples)
+
+	for _, layer := range manager.layers {
+		if err := pipeline.AttachAppsink(manager.appsinkName(layer), manager.sampleChannels[layer]); err != nil {
+			return err
+		}
+	}
+
+	if strings.Contains(pipeline.Src(), "name="+screenshotAppsinkName) {
+		samples := make(chan types.Sample, 1)
+		if err := pipeline.AttachAppsink(screenshotAppsinkName, samples); err != nil {
+			return err
+		}
+
+		go manager.watchScreenshotFrames(pipeline, samples)
+	}
+
+	if manager.recordingPath != "" && manager.recordingRebase && !pipeline.RebaseTimestamps(recordingName+"queue") {
+		manager.logger().Warn().Msg("unable to rebase recording timestamps")
+	}
+
+	return pipeline.Play()
+}
+
+// sets name of appsink samples of layer are taken from, empty layer for single
+// layer streams, it is used when pipeline is created next time
+func (manager *StreamSinkManagerCtx) SetAppsinkName(layer string, name string) error {
+	if !manager.hasLayer(layer) {
+		return fmt.Errorf("unknown layer %q", layer)
+	}
+
+	if name == "" {
+		return errors.New("appsink name must not be empty")
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.appsinkNames[layer] = name
+	return nil
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) appsinkName(layer string) string {
+	if name, ok := manager.appsinkNames[layer]; ok {
+		return name
+	}
+
+	appsinkSubfix := "audio"
+	if manager.Codec().IsVideo() {
+		appsinkSubfix = "video"
+	}
+
+	appsinkName := "appsink" + appsinkSubfix
+	if layer != "" {
+		appsinkName += "_" + layer
+	}
+
+	return appsinkName
+}
+
+// stores errors reported on the pipeline bus and restarts the failed pipeline
+// if there are listeners or broadcast, exits when the pipeline is destroyed
+func (manager *StreamSinkManagerCtx) watchErrors(pipeline types.Pipeline) {
+	for err := range pipeline.Errors() {
+		manager.logger().Err(err).Msg("pipeline error")
+		manager.emitEvent(types.PipelineEventError, err)
+
+		manager.pipelineMu.Lock()
+		current := manager.pipeline == pipeline
+		if current {
+			manager.lastError = err
+			if isBroadcastError(err) {
+				manager.broadcastError = err
+				manager.broadcastConnected = false
+			} else {
+				manager.pipelineErrored = true
+			}
+		}
+		active := manager.active()
+		manager.pipelineMu.Unlock()
+
+		if current && manager.restartAttempts > 0 && active {
+			manager.restartPipeline(pipeline)
+			return
+		}
+	}
+}
+
+// notifies handlers when pipeline reaches playing state, exits when the
+// pipeline is destroyed before
+func (manager *StreamSinkManagerCtx) watchPlaying(pipeline types.Pipeline) {
+	select {
+	case <-pipeline.Playing():
+	case <-pipeline.Destroyed():
+		return
+	}
+
+	manager.logger().Info().Msg("pipeline is playing")
+	manager.emitEvent(types.PipelineEventPlaying, nil)
+
+	manager.pipelineMu.Lock()
+	if manager.pipeline == pipeline && manager.broadcastUrl != "" {
+		manager.broadcastConnected = true
+		manager.logger().Info().Str("protocol", manager.broadcastProtocol).Msg("broadcast connected")
+	}
+	manager.pipelineMu.Unlock()
+
+	manager.playingHandlersMu.Lock()
+	handlers := manager.playingHandlers
+	manager.playingHandlersMu.Unlock()
+
+	for _, handler := range handlers {
+		handler()
+	}
+}
+
+// registers handler called every time a newly created pipeline reaches playing state
+func (manager *StreamSinkManagerCtx) OnPlaying(handler func()) {
+	manager.playingHandlersMu.Lock()
+	defer manager.playingHandlersMu.Unlock()
+
+	manager.playingHandlers = append(manager.playingHandlers, handler)
+}
+
+// blocks until current pipeline reaches playing state
+func (manager *StreamSinkManagerCtx) WaitForPlaying(timeout time.Duration) error {
+	manager.pipelineMu.Lock()
+	pipeline := manager.pipeline
+	manager.pipelineMu.Unlock()
+
+	if pipeline == nil {
+		return errors.New("pipeline is not created")
+	}
+
+	select {
+	case <-pipeline.Playing():
+		return nil
+	case <-pipeline.Destroyed():
+		return errors.New("pipeline was destroyed")
+	case <-time.After(timeout):
+		return fmt.Errorf("pipeline did not reach playing state within %s", timeout)
+	}
+}
+
+// recreates failed pipeline with exponential backoff, gives up when the pipeline
+// was recreated or destroyed meanwhile or after all attempts failed
+func (manager *StreamSinkManagerCtx) restartPipeline(failed types.Pipeline) {
+	manager.pipelineMu.Lock()
+	if manager.pipeline != failed {
+		manager.pipelineMu.Unlock()
+		return
+	}
+	manager.teardownPipeline()
+	manager.pipelineMu.Unlock()
+
+	backoff := restartBackoffMin
+	for attempt := 1; attempt <= manager.restartAttempts; attempt++ {
+		// watchdog waiting for restart must not block shutdown
+		select {
+		case <-manager.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > restartBackoffMax {
+			backoff = restartBackoffMax
+		}
+
+		manager.pipelineMu.Lock()
+		// pipeline was recreated meanwhile or is no longer needed
+		if manager.pipeline != nil || !manager.active() {
+			manager.pipelineMu.Unlock()
+			return
+		}
+
+		err := manager.buildPipeline()
+		if err == nil {
+			manager.restartCount++
+			manager.lastRestartAt = manager.pipelineCreatedAt
+		}
+		manager.pipelineMu.Unlock()
+
+		if err == nil {
+			manager.logger().Info().Int("attempt", attempt).Msg("pipeline restarted")
+			return
+		}
+
+		manager.logger().Warn().Err(err).Int("attempt", attempt).Msg("unable to restart pipeline")
+	}
+
+	manager.logger().Error().Int("attempts", manager.restartAttempts).Msg("giving up restarting pipeline")
+}
+
+// recreates pipeline destroyed from outside, e.g. because of screen size change,
+// if it is still needed. takes mu, so that it cannot race with last listener
+// leaving and leave pipeline running without listeners.
+func (manager *StreamSinkManagerCtx) restorePipeline() error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if !manager.Started() && !manager.inUse() && !manager.Prewarmed() {
+		return nil
+	}
+
+	err := manager.createPipeline()
+	if err != nil && !errors.Is(err, types.ErrCapturePipelineAlreadyExists) {
+		return err
+	}
+
+	return nil
+}
+
+func (manager *StreamSinkManagerCtx) destroyPipeline() {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return
+	}
+
+	manager.teardownPipeline()
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) teardownPipeline() {
+	// recording continues in new segment when pipeline is created again
+	if manager.recordingPath != "" {
+		_ = manager.endRecordingSegment()
+	}
+
+	manager.finishMuxers()
+	manager.stopPlaceholder()
+
+	manager.pipeline.Destroy()
+	manager.logger().Info().Msgf("destroying pipeline")
+	manager.pipeline = nil
+	manager.paused = false
+	manager.emitEvent(types.PipelineEventDestroyed, nil)
+
+	// shared sources are kept if recreated pipeline acquires them again
+	manager.acquiredSources = nil
+	if len(manager.sharedSources) > 0 {
+		go manager.releaseSharedSources()
+	}
+}
+
+// pauses running pipeline while keeping it and all listeners
+func (manager *StreamSinkManagerCtx) Pause() error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil || manager.paused {
+		return nil
+	}
+
+	if err := manager.pipeline.Pause(); err != nil {
+		return err
+	}
+
+	manager.paused = true
+	manager.stopPlaceholder()
+	manager.logger().Info().Msgf("pipeline paused")
+	return nil
+}
+
+func (manager *StreamSinkManagerCtx) Resume() error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil || !manager.paused {
+		return nil
+	}
+
+	if err := manager.pipeline.Play(); err != nil {
+		return err
+	}
+
+	manager.paused = false
+	manager.logger().Info().Msgf("pipeline resumed")
+	return nil
+}
+
+// when enabled, oldest buffered sample is dropped in favor of the newest one
+// when emitting falls behind, default for video. otherwise samples are kept in
+// order, default for audio.
+func (manager *StreamSinkManagerCtx) SetLatestOnly(enabled bool) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.latestOnly = enabled
+	if manager.pipeline != nil {
+		manager.pipeline.SetLatestOnly(enabled)
+	}
+}
+
+// when enabled, samples are not copied out of the pipeline, but reference its
+// buffers until released. it saves a copy and allocation per sample, but all
+// subscribers must release every received sample, see types.Sample.Release.
+func (manager *StreamSinkManagerCtx) SetLeaseBuffers(enabled bool) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.leaseBuffers = enabled
+	if manager.pipeline != nil {
+		manager.pipeline.SetLeaseBuffers(enabled)
+	}
+}
+
+// when enabled, pipeline is created immediately and kept running regardless of
+// listeners, e.g. for continuous recording. last listener leaving then neither
+// pauses nor destroys it, it is destroyed only by shutdown. when disabled, idle
+// pipeline is stopped as if the last listener left.
+func (manager *StreamSinkManagerCtx) SetAlwaysOn(enabled bool) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.pipelineMu.Lock()
+	if manager.alwaysOn == enabled {
+		manager.pipelineMu.Unlock()
+		return nil
+	}
+
+	manager.alwaysOn = enabled
+	manager.pipelineMu.Unlock()
+
+	if !enabled {
+		manager.logger().Info().Msgf("always on disabled")
+		manager.stop()
+		return nil
+	}
+
+	manager.logger().Info().Msgf("always on enabled, starting pipeline")
+
+	err := manager.createPipeline()
+	if err != nil && !errors.Is(err, types.ErrCapturePipelineAlreadyExists) {
+		return err
+	}
+
+	// pipeline may have been paused by last listener or prewarm
+	return manager.Resume()
+}
+
+func (manager *StreamSinkManagerCtx) AlwaysOn() bool {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.alwaysOn
+}
+
+func (manager *StreamSinkManagerCtx) logger() *zerolog.Logger {
+	return manager.currentLogger.Load()
+}
+
+// changes level of this manager logs only, e.g. debug for one troublesome
+// stream, level names are the ones of zerolog. global level still applies,
+// messages below it are not logged.
+func (manager *StreamSinkManagerCtx) SetLogLevel(level string) error {
+	lvl, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	// empty level is parsed as no level
+	if lvl == zerolog.NoLevel {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	logger := manager.baseLogger.Level(lvl)
+	manager.currentLogger.Store(&logger)
+	logger.Info().Str("level", lvl.String()).Msgf("log level changed")
+
+	if global := zerolog.GlobalLevel(); lvl < global {
+		logger.Warn().Str("global", global.String()).Msg("log level is below global level, it has no effect below it")
+	}
+
+	return nil
+}
+
+// when enabled, pipeline is paused instead of destroyed when last listener leaves
+func (manager *StreamSinkManagerCtx) SetPauseOnIdle(enabled bool) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.pauseOnIdle = enabled
+}
+
+// applies changed tunables to the running pipeline, in place if possible, otherwise
+// the pipeline is recreated. appsink and sample channel are kept, listeners are not
+// affected. must be called with pipelineMu held.
+func (manager *StreamSinkManagerCtx) reconfigure(live func(pipeline types.Pipeline) bool) error {
+	if manager.pipeline == nil {
+		return nil
+	}
+
+	if live(manager.pipeline) {
+		return nil
+	}
+
+	manager.logger().Info().Msgf("unable to reconfigure pipeline in place, recreating")
+	manager.teardownPipeline()
+	return manager.buildPipeline()
+}
+
+// broadcasts samples of a layer to all its subscribers, never blocks on slow
+// subscribers so that the pipeline is not stalled by them, exits on shutdown
+func (manager *StreamSinkManagerCtx) emit(layer string, samples chan types.Sample) {
+	var lastWarn time.Time
+	var droppedSinceWarn uint64
+	var generation, frames uint64
+	var lastPTS time.Duration = -1
+
+	// batches that are not filled in time are delivered when timer fires
+	var batchTimer *time.Timer
+	var batchFlush <-chan time.Time
+	var batchDeadline time.Time
+	scheduleBatches := func(next time.Time) {
+		if next.Equal(batchDeadline) {
+			return
+		}
+
+		if batchTimer != nil {
+			batchTimer.Stop()
+		}
+
+		batchDeadline = next
+		batchFlush = nil
+		if !next.IsZero() {
+			batchTimer = time.NewTimer(time.Until(next))
+			batchFlush = batchTimer.C
+		}
+	}
+
+	for {
+		var sample types.Sample
+		select {
+		case <-manager.ctx.Done():
+			manager.logger().Debug().Str("layer", layer).Msg("stopped emitting samples")
+			return
+		case <-batchFlush:
+			batchDeadline = time.Time{}
+			batchFlush = nil
+			dropped, next := manager.flushBatches(layer, time.Now())
+			droppedSinceWarn += dropped
+			scheduleBatches(next)
+			continue
+		case sample = <-samples:
+		}
+
+		// placeholder is stopped once by first sample of the pipeline, samples
+		// of stopped placeholder still waiting in the buffer are dropped
+		if sample.Placeholder {
+			if !manager.placeholderActive.Load() {
+				sample.Release()
+				continue
+			}
+		} else if manager.placeholderActive.CompareAndSwap(true, false) {
+			go func() {
+				manager.pipelineMu.Lock()
+				defer manager.pipelineMu.Unlock()
+
+				manager.stopPlaceholder()
+			}()
+		}
+
+		now := time.Now()
+		manager.emittedSamples.Add(1)
+		manager.emittedBytes.Add(uint64(len(sample.Data)))
+		manager.layerBytes[layer].Add(uint64(len(sample.Data)))
+		if !sample.Placeholder {
+			manager.lastSampleAt.Store(now.UnixNano())
+		}
+
+		// frames are counted per pipeline, as encoder does
+		if current := manager.pipelineGeneration.Load(); current != generation {
+			generation = current
+			frames = 0
+			lastPTS = -1
+
+			if layer == manager.layers[0] {
+				manager.frameStats.reset()
+				manager.bitrateStats.reset()
+			}
+		}
+		// payloader sets temporal layer of rtp packets in payload descriptor itself
+		rtp := manager.rtpOutputActive.Load() && parseRTPSample(&sample)
+		if !rtp && !sample.Placeholder {
+			sample.TemporalLayer = temporalLayer(manager.Codec().Name, frames)
+			frames++
+		}
+
+		// without duration, packets would be sent with the same rtp timestamp
+		if sample.Duration <= 0 && sample.PTS >= 0 && lastPTS >= 0 && sample.PTS > lastPTS {
+			sample.Duration = sample.PTS - lastPTS
+		}
+		if sample.PTS >= 0 {
+			lastPTS = sample.PTS
+		}
+
+		if hooks := manager.sampleHooks.Load(); hooks != nil {
+			var keep bool
+			if sample, keep = runSampleHooks(*hooks, sample); !keep {
+				sample.Release()
+				continue
+			}
+		}
+
+		if sample.Keyframe {
+			manager.keyframes.Add(1)
+		}
+		// placeholder is not captured content, it is only delivered
+		if !sample.Placeholder {
+			if layer == manager.layers[0] {
+				manager.frameStats.add(len(sample.Data), sample.Keyframe)
+				manager.bitrateStats.add(len(sample.Data), now)
+			}
+
+			if !manager.firstSampleSeen.Load() {
+				manager.markFirstSample()
+			}
+			manager.joinLatency.sample(time.Now())
+		}
+
+		manager.subscribersMu.RLock()
+		for id, subscriber := range manager.subscribers {
+			if subscriber.layer != layer {
+				continue
+			}
+
+			// every subscriber owns its reference
+			sample.Retain()
+
+			if subscriber.batch != nil {
+				subscriber.batch.add(sample, now)
+				continue
+			}
+
+			select {
+			case subscriber.samples <- sample:
+				subscriber.deliveredSamples.Add(1)
+				subscriber.deliveredBytes.Add(uint64(len(sample.Data)))
+			default:
+				sample.Release()
+				subscriber.droppedSamples.Add(1)
+				manager.droppedSamples.Add(1)
+				droppedSinceWarn++
+				manager.logger().Trace().Str("subscriber", id).Msg("subscriber buffer is full, dropping sample")
+			}
+		}
+		manager.subscribersMu.RUnlock()
+
+		// reference received from the pipeline is not needed anymore
+		sample.Release()
+
+		if manager.batchSubscribers.Load() > 0 {
+			dropped, next := manager.flushBatches(layer, now)
+			droppedSinceWarn += dropped
+			scheduleBatches(next)
+		}
+
+		if droppedSinceWarn >= droppedSamplesWarnThreshold && time.Since(lastWarn) >= droppedSamplesWarnInterval {
+			manager.logger().Warn().
+				Str("layer", layer).
+				Uint64("dropped", droppedSinceWarn).
+				Uint64("dropped_total", manager.droppedSamples.Load()).
+				Msg("subscribers are not keeping up, dropping samples")
+
+			lastWarn = time.Now()
+			droppedSinceWarn = 0
+		}
+	}
+}
+
+func (manager *StreamSinkManagerCtx) markFirstSample() {
+	manager.firstSampleMu.Lock()
+	defer manager.firstSampleMu.Unlock()
+
+	if manager.firstSampleSeen.CompareAndSwap(false, true) {
+		close(manager.firstSample)
+	}
+}
+
+func (manager *StreamSinkManagerCtx) resetFirstSample() {
+	manager.firstSampleMu.Lock()
+	defer manager.firstSampleMu.Unlock()
+
+	if manager.firstSampleSeen.CompareAndSwap(true, false) {
+		manager.firstSample = make(chan struct{})
+	}
+}
+
+// blocks until current pipeline emits its first sample, so that media is
+// flowing before the stream is offered to peers
+func (manager *StreamSinkManagerCtx) WaitForFirstSample(timeout time.Duration) error {
+	manager.firstSampleMu.Lock()
+	firstSample := manager.firstSample
+	manager.firstSampleMu.Unlock()
+
+	select {
+	case <-firstSample:
+		return nil
+	case <-manager.ctx.Done():
+		return errors.New("stream sink was shut down")
+	case <-time.After(timeout):
+		return fmt.Errorf("no sample received within %s", timeout)
+	}
+}
+
+// discards samples buffered between pipeline and subscribers, so that the next
+// sample subscribers receive is fresh, e.g. keyframe after reconfiguration.
+// samples are only received without blocking, so it is safe while emitting.
+func (manager *StreamSinkManagerCtx) Flush() {
+	var flushed int
+
+	for _, samples := range manager.sampleChannels {
+		flushed += drainSamples(samples)
+	}
+
+	manager.subscribersMu.RLock()
+	for _, subscriber := range manager.subscribers {
+		if subscriber.batch != nil {
+			flushed += subscriber.batch.drain()
+			continue
+		}
+
+		flushed += drainSamples(subscriber.samples)
+	}
+	manager.subscribersMu.RUnlock()
+
+	manager.logger().Debug().Int("flushed", flushed).Msg("flushed buffered samples")
+}
+
+func drainSamples(samples chan types.Sample) int {
+	for drained := 0; ; drained++ {
+		select {
+		case sample := <-samples:
+			sample.Release()
+		default:
+			return drained
+		}
+	}
+}
+
+// samples dropped by pipeline when emitting falls behind and by slow subscribers
+func (manager *StreamSinkManagerCtx) DroppedSamples() uint64 {
+	return manager.droppedSamples.Load()
+}
+
+// returns number of samples waiting in buffers of all layers and their total capacity
+func (manager *StreamSinkManagerCtx) SampleBufferUsage() (length int, capacity int) {
+	for _, samples := range manager.sampleChannels {
+		length += len(samples)
+		capacity += cap(samples)
+	}
+	return
+}
+
+func (manager *StreamSinkManagerCtx) Layers() []string {
+	return manager.layers
+}
+
+func (manager *StreamSinkManagerCtx) hasLayer(layer string) bool {
+	_, ok := manager.sampleChannels[layer]
+	return ok
+}
+
+func (manager *StreamSinkManagerCtx) Subscribe(id string) (<-chan types.Sample, error) {
+	return manager.SubscribeLayer(id, manager.layers[0])
+}
+
+func (manager *StreamSinkManagerCtx) SubscribeLayer(id string, layer string) (<-chan types.Sample, error) {
+	if !manager.hasLayer(layer) {
+		return nil, fmt.Errorf("unknown layer %s", layer)
+	}
+
+	manager.subscribersMu.Lock()
+	defer manager.subscribersMu.Unlock()
+
+	if _, ok := manager.subscribers[id]; ok {
+		return nil, fmt.Errorf("subscriber %s already exists", id)
+	}
+
+	subscriber := &streamSubscriber{
+		samples: make(chan types.Sample, subscriberBufferSize),
+		layer:   layer,
+	}
+	manager.subscribers[id] = subscriber
+
+	manager.logger().Debug().Str("subscriber", id).Str("layer", layer).Msg("subscribed")
+	return subscriber.samples, nil
+}
+
+// switches subscriber to another layer, samples keep coming on the same channel
+func (manager *StreamSinkManagerCtx) SetLayer(id string, layer string) error {
+	if !manager.hasLayer(layer) {
+		return fmt.Errorf("unknown layer %s", layer)
+	}
+
+	manager.subscribersMu.Lock()
+	defer manager.subscribersMu.Unlock()
+
+	subscriber, ok := manager.subscribers[id]
+	if !ok {
+		return fmt.Errorf("subscriber %s not found", id)
+	}
+
+	subscriber.layer = layer
+
+	manager.logger().Debug().Str("subscriber", id).Str("layer", layer).Msg("layer changed")
+	return nil
+}
+
+func (manager *StreamSinkManagerCtx) Unsubscribe(id string) {
+	manager.subscribersMu.Lock()
+	defer manager.subscribersMu.Unlock()
+
+	subscriber, ok := manager.subscribers[id]
+	if !ok {
+		return
+	}
+
+	delete(manager.subscribers, id)
+	manager.closeSubscriber(subscriber)
+
+	manager.logger().Debug().Str("subscriber", id).Msg("unsubscribed")
+}
+
+// must be called with subscribersMu held, after subscriber was removed
+func (manager *StreamSinkManagerCtx) closeSubscriber(subscriber *streamSubscriber) {
+	if subscriber.batch != nil {
+		manager.batchSubscribers.Add(-1)
+		subscriber.batch.close()
+		return
+	}
+
+	close(subscriber.samples)
+}
+
+// returns counters of samples passed to subscriber since it subscribed, so that
+// lagging one can be found among many
+func (manager *StreamSinkManagerCtx) ListenerStats(id string) (types.StreamListenerStats, error) {
+	manager.subscribersMu.RLock()
+	defer manager.subscribersMu.RUnlock()
+
+	subscriber, ok := manager.subscribers[id]
+	if !ok {
+		return types.StreamListenerStats{}, fmt.Errorf("subscriber %s not found", id)
+	}
+
+	buffered := len(subscriber.samples)
+	if subscriber.batch != nil {
+		buffered = len(subscriber.batch.batches)
+	}
+
+	return types.StreamListenerStats{
+		Layer:            subscriber.layer,
+		DeliveredSamples: subscriber.deliveredSamples.Load(),
+		DroppedSamples:   subscriber.droppedSamples.Load(),
+		DeliveredBytes:   subscriber.deliveredBytes.Load(),
+		Buffered:         buffered,
+	}, nil
+}
+
+func (manager *StreamSinkManagerCtx) SetBitrate(kbps int) error {
+	if kbps <= 0 {
+		return fmt.Errorf("invalid bitrate %d, must be a positive number of kbit/s", kbps)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	// bitrate of unknown encoder would break every rebuild
+	if _, err := setPipelineBitrate(manager.pipelineSrc(), kbps); err != nil {
+		return err
+	}
+
+	manager.bitrate = kbps
+	manager.logger().Info().Int("bitrate", kbps).Msgf("setting bitrate")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		prop, value, ok := pipelineBitrateProp(pipeline.Src(), kbps)
+		return ok && pipeline.SetPropInt(encoderName, prop, value)
+	})
+}
+
+// switches encoder between constant and variable bitrate, empty mode keeps
+// the pipeline default. applied in place if encoder supports it.
+func (manager *StreamSinkManagerCtx) SetRateControl(mode types.RateControlMode) error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	var prop, value string
+	var live bool
+	if mode != "" {
+		var err error
+		prop, value, live, err = pipelineRateControlProp(manager.pipelineSrc(), mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	manager.rateControl = mode
+	manager.logger().Info().Str("mode", string(mode)).Msgf("setting rate control")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return live && pipeline.SetPropString(encoderName, prop, value)
+	})
+}
+
+// trades encoding speed for quality, preset is validated against the encoder,
+// e.g. ultrafast..veryslow for x264enc or quality level 1..7 for vaapi encoders.
+// empty preset keeps the pipeline default. pipeline is recreated to apply it.
+func (manager *StreamSinkManagerCtx) SetEncoderPreset(preset string) error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if preset != "" {
+		if _, err := pipelineEncoderPresetProp(manager.pipelineSrc(), preset); err != nil {
+			return err
+		}
+	}
+
+	manager.encoderPreset = preset
+	manager.logger().Info().Str("preset", preset).Msgf("setting encoder preset")
+
+	// encoders do not apply presets while playing
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+// spreads intra coded blocks over frames instead of sending periodic keyframes,
+// so that bandwidth does not spike on every keyframe. supported only by x264enc,
+// error is returned for other encoders. pipeline is recreated to apply it.
+func (manager *StreamSinkManagerCtx) SetIntraRefresh(enabled bool) error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if enabled {
+		if _, err := pipelineIntraRefreshProp(manager.pipelineSrc()); err != nil {
+			return err
+		}
+	}
+
+	if manager.intraRefresh == enabled {
+		return nil
+	}
+
+	manager.intraRefresh = enabled
+	manager.logger().Info().Bool("enabled", enabled).Msgf("setting intra refresh")
+
+	// encoders do not switch it while playing
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+// sets maximum number of frames between keyframes, shorter interval lets
+// decoders recover sooner but costs bandwidth. zero keeps the pipeline default.
+// applied in place if encoder supports it, otherwise pipeline is recreated.
+func (manager *StreamSinkManagerCtx) SetKeyframeInterval(frames int) error {
+	if frames != 0 && (frames < minKeyframeInterval || frames > maxKeyframeInterval) {
+		return fmt.Errorf("invalid keyframe interval %d, must be between %d and %d frames", frames, minKeyframeInterval, maxKeyframeInterval)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	var prop string
+	var live bool
+	if frames > 0 {
+		var err error
+		prop, live, err = pipelineKeyframeIntervalProp(manager.pipelineSrc())
+		if err != nil {
+			return err
+		}
+	}
+
+	manager.keyframeInterval = frames
+	manager.logger().Info().Int("frames", frames).Msgf("setting keyframe interval")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return live && pipeline.SetPropInt(encoderName, prop, frames)
+	})
+}
+
+// limits quantizer of the encoder, so that quality stays within bounds while
+// bandwidth varies, lower is better quality. range is validated against the
+// encoder, e.g. 0-63 for vpx or 0-51 for h264 encoders. zero max keeps the
+// pipeline default. applied in place if encoder supports it, otherwise
+// pipeline is recreated.
+func (manager *StreamSinkManagerCtx) SetQP(min, max int) error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	var qp encoderQP
+	if max > 0 {
+		var err error
+		qp, err = pipelineQPProps(manager.pipelineSrc(), min, max)
+		if err != nil {
+			return err
+		}
+	} else {
+		min = 0
+	}
+
+	manager.qpMin = min
+	manager.qpMax = max
+	manager.logger().Info().Int("min", min).Int("max", max).Msgf("setting quantizer range")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return max > 0 && qp.live &&
+			pipeline.SetPropInt(encoderName, qp.minProp, min) &&
+			pipeline.SetPropInt(encoderName, qp.maxProp, max)
+	})
+}
+
+// returns bitrate in effect in kbit/s, zero if unknown
+func (manager *StreamSinkManagerCtx) GetBitrate() int {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.bitrate > 0 {
+		return manager.bitrate
+	}
+
+	return pipelineBitrate(manager.pipelineSrc())
+}
+
+// returns framerate in effect, zero if unknown
+func (manager *StreamSinkManagerCtx) GetFramerate() int16 {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.framerate()
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) framerate() int16 {
+	if manager.pipeline == nil && manager.adaptiveFramerate && manager.changeFramerate > 0 {
+		return manager.changeFramerate
+	}
+
+	return pipelineFramerate(manager.pipelineSrc())
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) pipelineParams() PipelineParams {
+	params := PipelineParams{
+		Codec:   manager.Codec(),
+		Bitrate: manager.bitrate,
+		Width:   manager.width,
+		Height:  manager.height,
+		Display: manager.display,
+
+		AudioSource: manager.audioSource,
+	}
+
+	if manager.adaptiveFramerate {
+		params.Framerate = manager.changeFramerate
+	}
+
+	return params
+}
+
+// returns source of running pipeline or the one that would be created,
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) pipelineSrc() string {
+	if manager.pipeline != nil {
+		return manager.pipeline.Src()
+	}
+
+	if manager.pipelineGraphFn != nil {
+		graph, err := manager.pipelineGraphFn(manager.pipelineParams())
+		if err != nil {
+			return ""
+		}
+
+		return graph.String()
+	}
+
+	pipelineStr, err := manager.pipelineFn(manager.pipelineParams())
+	if err != nil {
+		return ""
+	}
+
+	return pipelineStr
+}
+
+// scales video to given resolution, applied live if pipeline already scales
+// video, otherwise the pipeline is recreated
+func (manager *StreamSinkManagerCtx) SetResolution(width, height int) error {
+	rtpCodec := manager.Codec()
+	if !rtpCodec.IsVideo() {
+		return fmt.Errorf("unable to set resolution of %s stream", rtpCodec.Name)
+	}
+
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid resolution %dx%d, must be positive", width, height)
+	}
+
+	if codecRequiresEvenResolution[rtpCodec.Name] && (width%2 != 0 || height%2 != 0) {
+		return fmt.Errorf("invalid resolution %dx%d, codec %s requires it to be divisible by 2", width, height, rtpCodec.Name)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	// scaling is inserted before the encoder, so it must be found
+	if _, err := setPipelineResolution(manager.pipelineSrc(), width, height); err != nil {
+		return err
+	}
+
+	manager.width = width
+	manager.height = height
+	manager.logger().Info().Int("width", width).Int("height", height).Msgf("setting resolution")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return strings.Contains(pipeline.Src(), "name="+resolutionCapsName) &&
+			pipeline.SetCapsResolution(resolutionCapsName, width, height)
+	})
+}
+
+// framerate is applied only if adaptive framerate is enabled, live if pipeline
+// has named framerate capsfilter, otherwise the pipeline is recreated
+func (manager *StreamSinkManagerCtx) SetChangeFramerate(rate int16) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if clamped := manager.clampFramerate(rate); clamped != rate {
+		manager.logger().Warn().
+			Int16("requested", rate).
+			Int16("framerate", clamped).
+			Msgf("framerate is out of bounds, clamping it")
+		rate = clamped
+	}
+
+	manager.setChangeFramerate(rate)
+}
+
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) setChangeFramerate(rate int16) {
+	manager.changeFramerate = rate
+
+	if !manager.adaptiveFramerate || rate <= 0 {
+		return
+	}
+
+	manager.logger().Info().Int16("framerate", rate).Msgf("setting framerate")
+
+	err := manager.reconfigure(func(pipeline types.Pipeline) bool {
+		if !strings.Contains(pipeline.Src(), "name="+framerateCapsName) ||
+			!pipeline.SetCapsFramerate(framerateCapsName, int(rate), 1) {
+			return false
+		}
+
+		// keep source in sync, framerate in effect is read from it
+		src, _ := setPipelineFramerate(pipeline.Src(), rate)
+		pipeline.SetSrc(src)
+		return true
+	})
+
+	if err != nil {
+		manager.logger().Err(err).Int16("framerate", rate).Msg("unable to set framerate")
+	}
+}
+
+// limits framerate set by SetChangeFramerate and chosen by bandwidth adaptation,
+// zero leaves the bound open. framerate already in effect is clamped to them.
+func (manager *StreamSinkManagerCtx) SetFramerateBounds(min, max int16) error {
+	if min < 0 || max < 0 || (max > 0 && max < min) {
+		return fmt.Errorf("invalid framerate bounds %d-%d", min, max)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.minFramerate = min
+	manager.maxFramerate = max
+
+	if manager.changeFramerate > 0 {
+		if rate := manager.clampFramerate(manager.changeFramerate); rate != manager.changeFramerate {
+			manager.logger().Info().
+				Int16("previous", manager.changeFramerate).
+				Int16("framerate", rate).
+				Msgf("framerate is out of new bounds, clamping it")
+			manager.setChangeFramerate(rate)
+		}
+	}
+
+	return nil
+}
+
+// non-positive rate is returned as is, it means framerate is not changed,
+// must be called with pipelineMu held
+func (manager *StreamSinkManagerCtx) clampFramerate(rate int16) int16 {
+	if rate <= 0 {
+		return rate
+	}
+
+	if manager.minFramerate > 0 && rate < manager.minFramerate {
+		return manager.minFramerate
+	}
+	if manager.maxFramerate > 0 && rate > manager.maxFramerate {
+		return manager.maxFramerate
+	}
+
+	return rate
+}
+
+// tells whether framerate set by SetChangeFramerate has any effect, i.e. whether
+// pipeline sets framerate in caps, so that the control need not be offered
+func (manager *StreamSinkManagerCtx) SupportsAdaptiveFramerate() bool {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if !manager.Codec().IsVideo() {
+		return false
+	}
+
+	return framerateRegex.MatchString(manager.pipelineSrc())
+}
+
+func (manager *StreamSinkManagerCtx) SetAdaptiveFramerate(allow bool) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	manager.adaptiveFramerate = allow
+}
+
+// captures given X display and screen, e.g. :0.1, empty uses configured display.
+// pipeline is recreated, display is kept when it is recreated.
+func (manager *StreamSinkManagerCtx) SetDisplay(display string) error {
+	if rtpCodec := manager.Codec(); !rtpCodec.IsVideo() {
+		return fmt.Errorf("unable to set display of %s stream", rtpCodec.Name)
+	}
+
+	if display != "" && manager.displayExistsFn != nil && !manager.displayExistsFn(display) {
+		return fmt.Errorf("%w: display %s does not exist", types.ErrPipelineResource, display)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.display == display {
+		return nil
+	}
+
+	manager.display = display
+	manager.logger().Info().Str("display", display).Msgf("setting display")
+
+	// source properties are read only when it starts
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+func (manager *StreamSinkManagerCtx) Display() string {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.display
+}
+
+type captureRegion struct {
+	x, y, width, height int
+}
+
+// captures only given region of the display, zero width and height capture
+// whole display. pipeline is recreated, region is kept when it is recreated.
+func (manager *StreamSinkManagerCtx) SetCaptureRegion(x, y, width, height int) error {
+	rtpCodec := manager.Codec()
+	if !rtpCodec.IsVideo() {
+		return fmt.Errorf("unable to set capture region of %s stream", rtpCodec.Name)
+	}
+
+	var region *captureRegion
+	if width != 0 || height != 0 {
+		if x < 0 || y < 0 || width <= 0 || height <= 0 {
+			return fmt.Errorf("invalid region %dx%d+%d+%d", width, height, x, y)
+		}
+
+		if manager.displaySizeFn != nil {
+			displayWidth, displayHeight := manager.displaySizeFn()
+			if displayWidth > 0 && (x+width > displayWidth || y+height > displayHeight) {
+				return fmt.Errorf("region %dx%d+%d+%d does not fit display %dx%d", width, height, x, y, displayWidth, displayHeight)
+			}
+		}
+
+		if codecRequiresEvenResolution[rtpCodec.Name] && (width%2 != 0 || height%2 != 0) {
+			return fmt.Errorf("invalid region size %dx%d, codec %s requires it to be divisible by 2", width, height, rtpCodec.Name)
+		}
+
+		region = &captureRegion{x: x, y: y, width: width, height: height}
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	// fail early if source does not support it, current region is kept
+	if region != nil {
+		if _, err := setPipelineRegion(manager.pipelineSrc(), x, y, width, height); err != nil {
+			return err
+		}
+	}
+
+	manager.region = region
+	manager.logger().Info().
+		Int("x", x).Int("y", y).
+		Int("width", width).Int("height", height).
+		Msgf("setting capture region")
+
+	// source properties are read only when it starts
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+// shows or hides cursor in captured video, applied live if source element is
+// named, otherwise the pipeline is recreated. it is kept when pipeline is recreated.
+func (manager *StreamSinkManagerCtx) SetCursorVisible(visible bool) error {
+	if rtpCodec := manager.Codec(); !rtpCodec.IsVideo() {
+		return fmt.Errorf("unable to set cursor of %s stream", rtpCodec.Name)
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	// fail early if source does not support it
+	if _, err := setPipelineCursor(manager.pipelineSrc(), visible); err != nil {
+		return err
+	}
+
+	manager.cursorSet = true
+	manager.cursorVisible = visible
+	manager.logger().Info().Bool("visible", visible).Msgf("setting cursor visibility")
+
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		value := 0
+		if visible {
+			value = 1
+		}
+
+		if !strings.Contains(pipeline.Src(), "name="+sourceName) ||
+			!pipeline.SetPropInt(sourceName, "show-pointer", value) {
+			return false
+		}
+
+		// keep source in sync, so that it is reused correctly
+		src, _ := setPipelineCursor(pipeline.Src(), visible)
+		pipeline.SetSrc(src)
+		return true
+	})
+}
+
+// gets property of element in running pipeline serialized to string
+func (manager *StreamSinkManagerCtx) GetElementProperty(element string, prop string) (string, error) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return "", errors.New("pipeline is not running")
+	}
+
+	value, ok := manager.pipeline.GetPropString(element, prop)
+	if !ok {
+		return "", fmt.Errorf("unable to get property %s of element %s", prop, element)
+	}
+
+	return value, nil
+}
+
+// returns caps actually negotiated on the appsink of the default layer, they
+// can differ from requested ones when source cannot deliver them
+func (manager *StreamSinkManagerCtx) NegotiatedCaps() (string, error) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return "", errors.New("pipeline is not running")
+	}
+
+	if state := manager.pipeline.State(); state != "PLAYING" {
+		return "", fmt.Errorf("pipeline is in %s state, caps are negotiated when it is playing", state)
+	}
+
+	appsinkName := manager.appsinkName(manager.layers[0])
+	caps, ok := manager.pipeline.GetPadCaps(appsinkName, "sink")
+	if !ok {
+		return "", fmt.Errorf("caps of %s are not negotiated yet", appsinkName)
+	}
+
+	return caps, nil
+}
+
+// checks that pipeline string could be used by this stream without creating it,
+// i.e. that it parses, all its elements exist, it produces the configured codec
+// and has appsinks of all layers. nothing is started, so it can be used to
+// validate pipeline while it is edited.
+func (manager *StreamSinkManagerCtx) ValidatePipeline(pipelineStr string) error {
+	manager.pipelineMu.Lock()
+	codecName := manager.Codec().Name
+	appsinks := make([]string, 0, len(manager.layers))
+	for _, layer := range manager.layers {
+		appsinks = append(appsinks, manager.appsinkName(layer))
+	}
+	manager.pipelineMu.Unlock()
+
+	if _, err := validatePipelineCodec(pipelineStr, codecName); err != nil {
+		return err
+	}
+
+	for _, appsink := range appsinks {
+		if !strings.Contains(pipelineStr, "name="+appsink) {
+			return fmt.Errorf("%w: unable to find %s in pipeline", types.ErrPipelineParse, appsink)
+		}
+	}
+
+	return gst.ValidatePipeline(pipelineStr)
+}
+
+// returns latency reported by the pipeline, i.e. delay added by its elements
+// including the encoder, it is known only while the pipeline is playing
+func (manager *StreamSinkManagerCtx) Latency() (time.Duration, error) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return 0, errors.New("pipeline is not running")
+	}
+
+	if state := manager.pipeline.State(); state != "PLAYING" {
+		return 0, fmt.Errorf("pipeline is in %s state, latency is known when it is playing", state)
+	}
+
+	latency, ok := manager.pipeline.QueryLatency()
+	if !ok {
+		return 0, errors.New("pipeline did not answer latency query")
+	}
+
+	return latency, nil
+}
+
+// returns graph of the running pipeline in graphviz dot format, so that it can
+// be seen what was built after all changes. it is also written to
+// GST_DEBUG_DUMP_DOT_DIR if set.
+func (manager *StreamSinkManagerCtx) DumpPipelineDOT() (string, error) {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return "", errors.New("pipeline is not running")
+	}
+
+	dot, ok := manager.pipeline.DumpDot()
+	if !ok {
+		return "", errors.New("unable to dump pipeline graph")
+	}
+
+	return dot, nil
+}
+
+// sets property of element in running pipeline, value is converted from its
+// string representation. it is lost when pipeline is recreated.
+func (manager *StreamSinkManagerCtx) SetElementProperty(element string, prop string, value interface{}) error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return errors.New("pipeline is not running")
+	}
+
+	if !manager.pipeline.SetPropString(element, prop, fmt.Sprint(value)) {
+		return fmt.Errorf("unable to set property %s of element %s", prop, element)
+	}
+
+	return nil
+}
+
+func (manager *StreamSinkManagerCtx) ForceKeyframe() error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return nil
+	}
+
+	if !manager.pipeline.ForceKeyUnit() {
+		return errors.New("unable to force keyframe")
+	}
+
+	return nil
+}
+
+func (manager *StreamSinkManagerCtx) Status() types.StreamSinkStatus {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	status := types.StreamSinkStatus{
+		State:         "NULL",
+		ListenerCount: manager.ListenersCount(),
+		Codec:         manager.Codec().Name,
+		LastError:     manager.lastError,
+	}
+
+	status.SampleBufferLength, status.SampleBufferCapacity = manager.SampleBufferUsage()
+
+	if manager.pipeline != nil {
+		status.State = manager.pipeline.State()
+		status.Running = status.State == "PLAYING"
+	}
+
+	status.Framerate = manager.framerate()
+
+	return status
+}
+
+// returns snapshot of tunables and runtime state, it can be marshaled to json
+func (manager *StreamSinkManagerCtx) Config() types.StreamSinkConfig {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	config := types.StreamSinkConfig{
+		VideoID:           manager.id,
+		Codec:             manager.Codec().Name,
+		Framerate:         manager.framerate(),
+		AdaptiveFramerate: manager.adaptiveFramerate,
+		MinFramerate:      manager.minFramerate,
+		MaxFramerate:      manager.maxFramerate,
+		Bitrate:           manager.bitrate,
+		RateControl:       string(manager.rateControl),
+		EncoderPreset:     manager.encoderPreset,
+		IntraRefresh:      manager.intraRefresh,
+		KeyframeInterval:  manager.keyframeInterval,
+		Transform:         manager.transform,
+		QPMin:             manager.qpMin,
+		QPMax:             manager.qpMax,
+		QueueLeaky:        manager.queueLeaky,
+		QueueMaxBuffers:   manager.queueMaxBuffers,
+		Width:             manager.width,
+		Height:            manager.height,
+		Display:           manager.display,
+		AudioSource:       manager.audioSource,
+		PauseOnIdle:       manager.pauseOnIdle,
+		AlwaysOn:          manager.alwaysOn,
+		Placeholder:       manager.placeholder,
+		RTPOutput:         manager.rtpOutput,
+
+		State:        "NULL",
+		Paused:       manager.paused,
+		Prewarmed:    manager.prewarmed,
+		RestartCount: manager.restartCount,
+		Broadcasting: manager.broadcastUrl != "",
+		Recording:    manager.recordingPath != "",
+		HLS:          manager.hlsDir != "",
+	}
+
+	// single unnamed layer is not listed
+	if manager.layers[0] != "" {
+		config.Layers = append([]string{}, manager.layers...)
+	}
+
+	if config.Bitrate == 0 {
+		config.Bitrate = pipelineBitrate(manager.pipelineSrc())
+	}
+
+	if manager.cursorSet {
+		visible := manager.cursorVisible
+		config.CursorVisible = &visible
+	}
+
+	if manager.pipeline != nil {
+		config.State = manager.pipeline.State()
+		config.Uptime = time.Since(manager.pipelineCreatedAt).Seconds()
+	}
+
+	manager.listenersMu.Lock()
+	config.Listeners = manager.listeners
+	config.MaxListeners = manager.maxListeners
+	manager.listenersMu.Unlock()
+
+	return config
+}
+
+func (manager *StreamSinkManagerCtx) running() bool {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.pipeline != nil && manager.pipeline.State() == "PLAYING"
+}
+
+// returns how long the current pipeline is running, zero if there is none
+func (manager *StreamSinkManagerCtx) Uptime() time.Duration {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.pipeline == nil {
+		return 0
+	}
+
+	return time.Since(manager.pipelineCreatedAt)
+}
+
+// returns how many times failed pipeline was restarted
+func (manager *StreamSinkManagerCtx) RestartCount() int {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.restartCount
+}
+
+// returns when failed pipeline was last restarted, zero if never
+func (manager *StreamSinkManagerCtx) LastRestart() time.Time {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.lastRestartAt
+}
+
+func (manager *StreamSinkManagerCtx) LastError() error {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.lastError
 }
@@ -0,0 +1,514 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"m1k1o/neko/internal/types"
+	"m1k1o/neko/internal/types/codec"
+)
+
+// run with -race, framerate changes rebuild pipeline while listeners
+// create and destroy it
+func TestSetChangeFramerateConcurrentListeners(t *testing.T) {
+	pipelines := &fakePipelines{refuseLive: true}
+	manager := newTestManager(t, pipelines)
+	manager.SetAdaptiveFramerate(true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				if err := manager.AddListener(); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := manager.RemoveListener(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for j := 0; j < 100; j++ {
+			manager.SetChangeFramerate(int16(10 + j%20))
+		}
+	}()
+
+	wg.Wait()
+
+	if count := manager.ListenersCount(); count != 0 {
+		t.Fatalf("%d listeners are left", count)
+	}
+
+	if pipeline := currentPipeline(manager); pipeline != nil {
+		t.Fatal("pipeline is left running without listeners")
+	}
+
+	if _, alive, maxAlive := pipelines.count(); alive != 0 || maxAlive > 1 {
+		t.Fatalf("%d pipelines are left alive, %d were alive at once", alive, maxAlive)
+	}
+}
+
+// pipeline recreated without listeners must not keep playing
+func TestPauseOnIdleRebuild(t *testing.T) {
+	pipelines := &fakePipelines{refuseLive: true}
+	manager := newTestManager(t, pipelines)
+	manager.SetPauseOnIdle(true)
+
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := currentPipeline(manager)
+	if _, pauses, _ := first.calls(); pauses != 0 {
+		t.Fatal("pipeline started for listener was paused")
+	}
+
+	if err := manager.RemoveListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SetBitrate(2000); err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt := currentPipeline(manager)
+	if rebuilt == nil || rebuilt == first {
+		t.Fatal("pipeline was not recreated")
+	}
+
+	if state := rebuilt.State(); state != "PAUSED" {
+		t.Fatalf("recreated idle pipeline is %s, expected PAUSED", state)
+	}
+}
+
+// pipeline that fails to play must not be kept
+func TestPlayErrorDestroysPipeline(t *testing.T) {
+	pipelines := &fakePipelines{playErr: fmt.Errorf("%w: unable to set pipeline to playing state", types.ErrPipelinePlay)}
+	manager := newTestManager(t, pipelines)
+
+	err := manager.AddListener()
+	if !errors.Is(err, types.ErrPipelinePlay) {
+		t.Fatalf("expected play error, got %v", err)
+	}
+
+	pipeline := pipelines.last()
+	if pipeline == nil {
+		t.Fatal("pipeline was not created")
+	}
+
+	if _, _, destroys := pipeline.calls(); destroys != 1 {
+		t.Fatalf("pipeline was destroyed %d times, expected once", destroys)
+	}
+
+	manager.pipelineMu.Lock()
+	kept := manager.pipeline != nil
+	manager.pipelineMu.Unlock()
+
+	if kept {
+		t.Fatal("pipeline that failed to play was kept")
+	}
+
+	if count := manager.ListenersCount(); count != 0 {
+		t.Fatalf("listener was added although pipeline failed, %d listeners", count)
+	}
+}
+
+// rebuilt pipeline continues recording in next segment
+func TestRecordingSurvivesRebuild(t *testing.T) {
+	pipelines := &fakePipelines{refuseLive: true}
+	manager := newTestManager(t, pipelines)
+
+	path := filepath.Join(t.TempDir(), "rec.webm")
+	if err := manager.StartRecording(path, "webm"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SetBitrate(2000); err != nil {
+		t.Fatal(err)
+	}
+
+	status := manager.RecordingStatus()
+	if !status.Active || !status.Running {
+		t.Fatal("recording was stopped by rebuild")
+	}
+
+	segment := filepath.Join(filepath.Dir(path), "rec.1.webm")
+	if expected := []string{path, segment}; !reflect.DeepEqual(status.Files, expected) {
+		t.Fatalf("recording files are %v, expected %v", status.Files, expected)
+	}
+
+	if src := currentPipeline(manager).Src(); !strings.Contains(src, "location='"+segment+"'") {
+		t.Fatalf("rebuilt pipeline does not record to next segment: %s", src)
+	}
+
+	if err := manager.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := manager.RecordingStatus(); status.Active || len(status.Files) != 2 {
+		t.Fatalf("unexpected status of stopped recording %+v", status)
+	}
+}
+
+// framerate in effect is read from source, it must be updated also when
+// framerate is changed in place
+func TestSetChangeFramerateUpdatesSrc(t *testing.T) {
+	for _, refuseLive := range []bool{false, true} {
+		t.Run(fmt.Sprintf("refuseLive=%v", refuseLive), func(t *testing.T) {
+			pipelines := &fakePipelines{refuseLive: refuseLive}
+			manager := newTestManager(t, pipelines)
+			manager.SetAdaptiveFramerate(true)
+
+			if err := manager.AddListener(); err != nil {
+				t.Fatal(err)
+			}
+
+			first := currentPipeline(manager)
+			manager.SetChangeFramerate(15)
+
+			pipeline := currentPipeline(manager)
+			if recreated := pipeline != first; recreated != refuseLive {
+				t.Fatalf("pipeline recreated %v, expected %v", recreated, refuseLive)
+			}
+
+			if src := pipeline.Src(); !strings.Contains(src, "framerate=15/1") {
+				t.Fatalf("framerate is missing in pipeline source: %s", src)
+			}
+
+			if rate := manager.GetFramerate(); rate != 15 {
+				t.Fatalf("framerate is %d, expected 15", rate)
+			}
+		})
+	}
+}
+
+// pipeline is created once for concurrent listeners and destroyed after last
+// of them leaves, it is never created twice or left behind
+func TestInterleavedListeners(t *testing.T) {
+	pipelines := &fakePipelines{}
+	manager := newTestManager(t, pipelines)
+
+	const workers, rounds = 8, 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < rounds; j++ {
+				if err := manager.AddListener(); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := manager.RemoveListener(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, alive, maxAlive := pipelines.count(); alive != 0 || maxAlive != 1 {
+		t.Fatalf("%d pipelines are left alive, %d were alive at once", alive, maxAlive)
+	}
+
+	// all listeners share one pipeline
+	created, _, _ := pipelines.count()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := manager.AddListener(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := manager.ListenersCount(); count != workers {
+		t.Fatalf("%d listeners, expected %d", count, workers)
+	}
+
+	if now, _, _ := pipelines.count(); now != created+1 {
+		t.Fatalf("%d pipelines were created for concurrent listeners, expected one", now-created)
+	}
+
+	for i := 0; i < workers; i++ {
+		if err := manager.RemoveListener(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, alive, _ := pipelines.count(); alive != 0 {
+		t.Fatalf("%d pipelines are left alive", alive)
+	}
+}
+
+// codec is read by emit while it is changed, tunables the new encoder does
+// not support are cleared, the others are kept
+func TestSetCodecClearsUnsupportedTunables(t *testing.T) {
+	pipelines := &fakePipelines{}
+	manager := newTestManager(t, pipelines)
+
+	if err := manager.SetQP(10, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetKeyframeInterval(60); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	// samples are emitted while codec is changed
+	pipeline := currentPipeline(manager)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			pipeline.push("appsinkvideo", types.Sample{PTS: -1})
+		}
+	}()
+
+	if err := manager.RemoveListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := manager.SetCodec(codec.H264(), func(params PipelineParams) (string, error) {
+		return fmt.Sprintf(videoSrc, ":0", 25) + "x264enc name=encoder ! appsink name=appsinkvideo", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	config := manager.Config()
+	if config.QPMin != 0 || config.QPMax != 0 {
+		t.Fatalf("qp range %d-%d out of x264enc range was kept", config.QPMin, config.QPMax)
+	}
+
+	if config.KeyframeInterval != 60 {
+		t.Fatalf("supported keyframe interval was cleared, it is %d", config.KeyframeInterval)
+	}
+
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.RemoveListener()
+
+	if src := currentPipeline(manager).Src(); !strings.Contains(src, "key-int-max=60") {
+		t.Fatalf("keyframe interval is missing in pipeline source: %s", src)
+	}
+}
+
+// subscriber that never reads must not block shutdown
+func TestShutdownWithStuckConsumer(t *testing.T) {
+	pipelines := &fakePipelines{}
+	manager := newTestManager(t, pipelines)
+
+	samples, err := manager.Subscribe("stuck")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	// fill both sample buffer and subscriber buffer
+	pipeline := currentPipeline(manager)
+	for i := 0; i < 2*(defaultSampleBufferSize+subscriberBufferSize); i++ {
+		pipeline.push("appsinkvideo", types.Sample{PTS: -1})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		manager.shutdown()
+		close(done)
+	}()
+
+	// goroutines exiting only by shutdown timeout would be a failure too
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout / 2):
+		t.Fatal("shutdown is blocked by stuck consumer")
+	}
+
+	if _, _, destroys := pipeline.calls(); destroys != 1 {
+		t.Fatalf("pipeline was destroyed %d times, expected once", destroys)
+	}
+
+	// buffered samples are still readable, then channel is closed
+	for range samples {
+	}
+}
+
+// removing listener that was not added is ignored and does not stop stream
+// of others
+func TestRemoveListenerWithoutAdd(t *testing.T) {
+	pipelines := &fakePipelines{}
+	manager := newTestManager(t, pipelines)
+
+	if err := manager.RemoveListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := manager.ListenersCount(); count != 0 {
+		t.Fatalf("%d listeners after removing unknown listener", count)
+	}
+
+	if created, _, _ := pipelines.count(); created != 0 {
+		t.Fatalf("%d pipelines were created", created)
+	}
+
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	// double disconnect of the same listener
+	for i := 0; i < 3; i++ {
+		if err := manager.RemoveListener(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count := manager.ListenersCount(); count != 0 {
+		t.Fatalf("listeners count is %d, expected 0", count)
+	}
+
+	pipeline := pipelines.last()
+	if _, _, destroys := pipeline.calls(); destroys != 1 {
+		t.Fatalf("pipeline was destroyed %d times, expected once", destroys)
+	}
+
+	// stream still works after unmatched removals
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := manager.ListenersCount(); count != 1 {
+		t.Fatalf("listeners count is %d, expected 1", count)
+	}
+
+	if err := manager.RemoveListener(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tunables set while stream is idle are applied to every pipeline created
+// later, not only to the first one
+func TestTunablesPersistAcrossRebuild(t *testing.T) {
+	pipelines := &fakePipelines{}
+	manager := newTestManager(t, pipelines)
+
+	if err := manager.SetBitrate(1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetKeyframeInterval(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetQP(10, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetResolution(1280, 720); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := manager.AddListener(); err != nil {
+			t.Fatal(err)
+		}
+
+		src := currentPipeline(manager).Src()
+		for _, expected := range []string{
+			"target-bitrate=650000",
+			"keyframe-max-dist=60",
+			"min-quantizer=10",
+			"max-quantizer=50",
+			"video/x-raw,width=1280,height=720",
+		} {
+			if !strings.Contains(src, expected) {
+				t.Fatalf("pipeline %d is missing %s: %s", i+1, expected, src)
+			}
+		}
+
+		if err := manager.RemoveListener(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if created, _, _ := pipelines.count(); created != 2 {
+		t.Fatalf("%d pipelines were created, expected one per listener cycle", created)
+	}
+}
+
+// bitrate of encoder without known bitrate property is rejected, so that it
+// does not break creating the pipeline
+func TestSetBitrateUnknownEncoder(t *testing.T) {
+	pipelines := &fakePipelines{}
+	manager := streamSinkNew(codec.PCMU(), func(params PipelineParams) (string, error) {
+		return "audiotestsrc ! mulawenc name=encoder ! appsink name=appsinkaudio", nil
+	}, "audio", 0, nil, 0, 0, zerolog.Nop())
+	manager.createPipelineFn = pipelines.create
+	t.Cleanup(manager.shutdown)
+
+	if err := manager.SetBitrate(64); !errors.Is(err, types.ErrPipelineParse) {
+		t.Fatalf("SetBitrate returned %v, expected %v", err, types.ErrPipelineParse)
+	}
+
+	if err := manager.AddListener(); err != nil {
+		t.Fatal(err)
+	}
+
+	if src := currentPipeline(manager).Src(); strings.Contains(src, "bitrate") {
+		t.Fatalf("rejected bitrate was applied: %s", src)
+	}
+}
+
+// reset manager has log level and screenshot max age it was created with
+func TestResetRestoresDefaults(t *testing.T) {
+	manager := newTestManager(t, &fakePipelines{})
+
+	if err := manager.SetLogLevel("trace"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetScreenshotMaxAge(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	if manager.logger() != &manager.baseLogger {
+		t.Fatal("log level was not reset")
+	}
+	if maxAge := time.Duration(manager.screenshotMaxAge.Load()); maxAge != defaultScreenshotMaxAge {
+		t.Fatalf("screenshot max age is %s, expected %s", maxAge, defaultScreenshotMaxAge)
+	}
+}
@@ -0,0 +1,81 @@
+package capture
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"m1k1o/neko/internal/config"
+	"m1k1o/neko/internal/types"
+	"m1k1o/neko/internal/types/codec"
+)
+
+// test sources replacing capture sources, named the same so that pipeline
+// changes referring to the source still find it
+var testPatternSrcs = map[string]string{
+	"ximagesrc": "videotestsrc name=source is-live=true pattern=smpte ",
+	"pulsesrc":  "audiotestsrc name=source is-live=true wave=sine ",
+}
+
+// replaces capture source of the main chain of pipeline string by test pattern
+func testPatternPipelineStr(pipelineStr string) (string, error) {
+	elements := strings.Split(pipelineStr, "!")
+
+	fields := strings.Fields(elements[0])
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: pipeline is empty", types.ErrPipelineParse)
+	}
+
+	src, ok := testPatternSrcs[fields[0]]
+	if !ok {
+		return "", fmt.Errorf("source element %s does not support test pattern", fields[0])
+	}
+
+	elements[0] = src
+	return strings.Join(elements, "!"), nil
+}
+
+// returns pipelineFn building the same pipeline as capture of given codec, but
+// from test pattern instead of X display or pulseaudio device
+func TestPatternPipelineFn(rtpCodec codec.RTPCodec) func(params PipelineParams) (string, error) {
+	return func(params PipelineParams) (string, error) {
+		var pipelineStr string
+		var err error
+
+		if rtpCodec.IsVideo() {
+			fps := params.Framerate
+			if fps <= 0 {
+				fps = defaultFramerate
+			}
+
+			bitrate := params.Bitrate
+			if bitrate <= 0 {
+				bitrate = defaultVideoBitrate
+			}
+
+			// display is not used, but must be valid for the pipeline string
+			pipelineStr, err = NewVideoPipeline(rtpCodec, ":0", "", fps, uint(bitrate), config.HwEncNone)
+		} else {
+			bitrate := params.Bitrate
+			if bitrate <= 0 {
+				bitrate = defaultAudioBitrate
+			}
+
+			pipelineStr, err = NewAudioPipeline(rtpCodec, "default", "", uint(bitrate), AudioParams{})
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		return testPatternPipelineStr(pipelineStr)
+	}
+}
+
+// returns stream sink that behaves as captured one, but needs no X display or
+// audio device, so that the whole path to transports can be tested in CI.
+// the pipeline is not restarted on failure, so that errors are not hidden.
+func NewTestPatternSink(rtpCodec codec.RTPCodec, logger zerolog.Logger) *StreamSinkManagerCtx {
+	return streamSinkNew(rtpCodec, TestPatternPipelineFn(rtpCodec), "test", 0, nil, 0, 0, logger)
+}
@@ -0,0 +1,115 @@
+package capture
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"m1k1o/neko/internal/types"
+)
+
+// name of videoflip element, so that its method can be rewritten
+const transformName = "transform"
+
+var transformMethodRe = regexp.MustCompile(`method=\S+`)
+
+// methods of videoflip element
+var transformMethods = []string{
+	"none",
+	"clockwise",
+	"rotate-180",
+	"counterclockwise",
+	"horizontal-flip",
+	"vertical-flip",
+	"upper-left-diagonal",
+	"upper-right-diagonal",
+	"automatic",
+}
+
+func validateTransform(method string) error {
+	for _, m := range transformMethods {
+		if m == method {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid transform %s, valid methods are %s", method, strings.Join(transformMethods, ", "))
+}
+
+// rewrite method of videoflip element in pipeline string, it is inserted before
+// scaling or the encoder if missing, so that scaling sets the output size
+func setPipelineTransform(pipelineStr string, method string) (string, error) {
+	elements := strings.Split(pipelineStr, "!")
+
+	for i, el := range elements {
+		if !strings.Contains(el, "name="+transformName) {
+			continue
+		}
+
+		elements[i] = transformMethodRe.ReplaceAllString(el, "method="+method)
+		return strings.Join(elements, "!"), nil
+	}
+
+	i, _, ok := findEncoder(elements)
+	if !ok {
+		return "", fmt.Errorf("%w: no known encoder found in pipeline", types.ErrPipelineParse)
+	}
+
+	for j, el := range elements[:i] {
+		if strings.Contains(el, "name="+resolutionCapsName) {
+			i = j
+			if j > 0 && strings.HasPrefix(strings.TrimSpace(elements[j-1]), "videoscale") {
+				i = j - 1
+			}
+			break
+		}
+	}
+
+	flip := fmt.Sprintf(" videoflip name=%s method=%s ", transformName, method)
+	elements = append(elements[:i], append([]string{flip}, elements[i:]...)...)
+	return strings.Join(elements, "!"), nil
+}
+
+// flips or rotates captured video using videoflip method, e.g. horizontal-flip
+// or clockwise. empty method removes the transform. it is kept when pipeline is
+// recreated, pipeline is recreated to apply it.
+func (manager *StreamSinkManagerCtx) SetTransform(method string) error {
+	if rtpCodec := manager.Codec(); !rtpCodec.IsVideo() {
+		return fmt.Errorf("unable to set transform of %s stream", rtpCodec.Name)
+	}
+
+	if method != "" {
+		if err := validateTransform(method); err != nil {
+			return err
+		}
+	}
+
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	if manager.transform == method {
+		return nil
+	}
+
+	// videoflip is inserted before the encoder, so it must be found
+	if method != "" {
+		if _, err := setPipelineTransform(manager.pipelineSrc(), method); err != nil {
+			return err
+		}
+	}
+
+	manager.transform = method
+	manager.logger().Info().Str("method", method).Msgf("setting transform")
+
+	// rotation changes frame size, which caps are already negotiated for
+	return manager.reconfigure(func(pipeline types.Pipeline) bool {
+		return false
+	})
+}
+
+func (manager *StreamSinkManagerCtx) Transform() string {
+	manager.pipelineMu.Lock()
+	defer manager.pipelineMu.Unlock()
+
+	return manager.transform
+}
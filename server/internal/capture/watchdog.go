@@ -0,0 +1,64 @@
+package capture
+
+import (
+	"time"
+)
+
+// returns when the last sample was emitted, zero if none was
+func (manager *StreamSinkManagerCtx) LastSampleTime() time.Time {
+	nano := manager.lastSampleAt.Load()
+	if nano == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nano)
+}
+
+// checks that playing pipeline with listeners keeps producing samples, stalled
+// pipeline is restarted if restarts are enabled, exits on shutdown
+func (manager *StreamSinkManagerCtx) watchdog(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	var warned bool
+	for {
+		select {
+		case <-manager.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		manager.pipelineMu.Lock()
+		pipeline := manager.pipeline
+		since := manager.pipelineCreatedAt
+		stallable := pipeline != nil && !manager.paused && manager.Started()
+		manager.pipelineMu.Unlock()
+
+		if !stallable {
+			warned = false
+			continue
+		}
+
+		// samples of previous pipeline do not count
+		if last := manager.LastSampleTime(); last.After(since) {
+			since = last
+		}
+
+		stalled := time.Since(since)
+		if stalled < timeout {
+			warned = false
+			continue
+		}
+
+		if manager.restartAttempts > 0 {
+			manager.logger().Warn().Dur("stalled", stalled).Msg("pipeline stopped producing samples, restarting")
+			manager.restartPipeline(pipeline)
+			continue
+		}
+
+		if !warned {
+			manager.logger().Warn().Dur("stalled", stalled).Msg("pipeline stopped producing samples")
+			warned = true
+		}
+	}
+}
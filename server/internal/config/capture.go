@@ -2,7 +2,9 @@ package config
 
 import (
 	"m1k1o/neko/internal/types/codec"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog/log"
@@ -12,10 +14,17 @@ import (
 
 type HwEnc int
 
+type VideoLayer struct {
+	Name    string
+	Bitrate uint
+}
+
 const (
 	HwEncNone HwEnc = iota
 	HwEncVAAPI
 	HwEncNVENC
+	// detected when capture starts
+	HwEncAuto
 )
 
 type Capture struct {
@@ -26,17 +35,27 @@ type Capture struct {
 	VideoBitrate  uint  // TODO: Pipeline builder.
 	VideoMaxFPS   int16 // TODO: Pipeline builder.
 	VideoPipeline string
+	VideoLayers   []VideoLayer
 
 	// audio
 	AudioDevice   string
 	AudioCodec    codec.RTPCodec
 	AudioBitrate  uint // TODO: Pipeline builder.
 	AudioPipeline string
+	// zero for codec default
+	AudioChannels   int
+	AudioSampleRate int
 
 	// broadcast
 	BroadcastPipeline  string
 	BroadcastUrl       string
 	BroadcastAutostart bool
+
+	// stream pipelines
+	PipelineRestartAttempts int
+	SampleBufferSize        int
+	SampleStallTimeout      time.Duration
+	ScreenshotMaxAge        time.Duration
 }
 
 func (Capture) Init(cmd *cobra.Command) error {
@@ -49,7 +68,7 @@ func (Capture) Init(cmd *cobra.Command) error {
 		return err
 	}
 
-	cmd.PersistentFlags().String("video_codec", "vp8", "video codec to be used")
+	cmd.PersistentFlags().String("video_codec", "vp8", "video codec to be used, vp9-svc for vp9 with temporal layers")
 	if err := viper.BindPFlag("video_codec", cmd.PersistentFlags().Lookup("video_codec")); err != nil {
 		return err
 	}
@@ -78,7 +97,7 @@ func (Capture) Init(cmd *cobra.Command) error {
 		return err
 	}
 
-	cmd.PersistentFlags().String("hwenc", "", "use hardware accelerated encoding")
+	cmd.PersistentFlags().String("hwenc", "", "use hardware accelerated encoding: none, vaapi, nvenc or auto to use available one")
 	if err := viper.BindPFlag("hwenc", cmd.PersistentFlags().Lookup("hwenc")); err != nil {
 		return err
 	}
@@ -98,6 +117,11 @@ func (Capture) Init(cmd *cobra.Command) error {
 		return err
 	}
 
+	cmd.PersistentFlags().StringSlice("video_layers", []string{}, "simulcast quality layers as name:bitrate in kbit/s, first one is default, e.g. high:3072,low:512")
+	if err := viper.BindPFlag("video_layers", cmd.PersistentFlags().Lookup("video_layers")); err != nil {
+		return err
+	}
+
 	//
 	// audio
 	//
@@ -142,6 +166,16 @@ func (Capture) Init(cmd *cobra.Command) error {
 		return err
 	}
 
+	cmd.PersistentFlags().Int("audio_channels", 0, "number of captured audio channels, 1 or 2, 0 for default stereo")
+	if err := viper.BindPFlag("audio_channels", cmd.PersistentFlags().Lookup("audio_channels")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("audio_sample_rate", 0, "sample rate of captured audio in Hz, must be supported by audio codec, 0 for default")
+	if err := viper.BindPFlag("audio_sample_rate", cmd.PersistentFlags().Lookup("audio_sample_rate")); err != nil {
+		return err
+	}
+
 	cmd.PersistentFlags().String("audio", "", "audio codec parameters to use for streaming")
 	if err := viper.BindPFlag("audio", cmd.PersistentFlags().Lookup("audio")); err != nil {
 		return err
@@ -166,6 +200,30 @@ func (Capture) Init(cmd *cobra.Command) error {
 		return err
 	}
 
+	//
+	// stream pipelines
+	//
+
+	cmd.PersistentFlags().Int("pipeline_restart_attempts", 5, "how many times to try recreating a failed stream pipeline, 0 disables it")
+	if err := viper.BindPFlag("pipeline_restart_attempts", cmd.PersistentFlags().Lookup("pipeline_restart_attempts")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("sample_buffer_size", 100, "how many samples can be buffered between a stream pipeline and its consumers, smaller lowers latency and memory use but drops samples sooner")
+	if err := viper.BindPFlag("sample_buffer_size", cmd.PersistentFlags().Lookup("sample_buffer_size")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("sample_stall_timeout", 0, "seconds a stream pipeline with listeners may not produce samples before it is restarted, 0 disables it")
+	if err := viper.BindPFlag("sample_stall_timeout", cmd.PersistentFlags().Lookup("sample_stall_timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("screenshot_max_age", 2000, "milliseconds a screenshot is served from cache before a new one is taken, 0 disables the cache")
+	if err := viper.BindPFlag("screenshot_max_age", cmd.PersistentFlags().Lookup("screenshot_max_age")); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -209,6 +267,8 @@ func (s *Capture) Set() {
 		s.VideoHWEnc = HwEncVAAPI
 	case "nvenc":
 		s.VideoHWEnc = HwEncNVENC
+	case "auto":
+		s.VideoHWEnc = HwEncAuto
 	default:
 		log.Warn().Str("hwenc", videoHWEnc).Msgf("unknown video hw encoder, using CPU")
 	}
@@ -217,6 +277,26 @@ func (s *Capture) Set() {
 	s.VideoMaxFPS = int16(viper.GetInt("max_fps"))
 	s.VideoPipeline = viper.GetString("video")
 
+	s.VideoLayers = []VideoLayer{}
+	for _, layer := range viper.GetStringSlice("video_layers") {
+		name, bitrate, ok := strings.Cut(layer, ":")
+		if !ok || name == "" || strings.ContainsAny(name, " !.") {
+			log.Warn().Str("layer", layer).Msgf("invalid video layer, expected name:bitrate")
+			continue
+		}
+
+		value, err := strconv.ParseUint(bitrate, 10, 32)
+		if err != nil || value == 0 {
+			log.Warn().Str("layer", layer).Msgf("invalid video layer bitrate")
+			continue
+		}
+
+		s.VideoLayers = append(s.VideoLayers, VideoLayer{
+			Name:    name,
+			Bitrate: uint(value),
+		})
+	}
+
 	//
 	// audio
 	//
@@ -230,6 +310,12 @@ func (s *Capture) Set() {
 		s.AudioCodec = codec.Opus()
 	}
 
+	// aac is only transcoded for broadcast and hls, webrtc stays opus
+	if s.AudioCodec.Name == codec.AAC().Name {
+		log.Warn().Str("codec", audioCodec).Msgf("audio codec is not supported by webrtc, using Opus")
+		s.AudioCodec = codec.Opus()
+	}
+
 	if viper.GetBool("opus") {
 		s.AudioCodec = codec.Opus()
 		log.Warn().Msg("you are using deprecated config setting 'NEKO_OPUS=true', use 'NEKO_VIDEO_CODEC=opus' instead")
@@ -246,6 +332,8 @@ func (s *Capture) Set() {
 
 	s.AudioBitrate = viper.GetUint("audio_bitrate")
 	s.AudioPipeline = viper.GetString("audio")
+	s.AudioChannels = viper.GetInt("audio_channels")
+	s.AudioSampleRate = viper.GetInt("audio_sample_rate")
 
 	//
 	// broadcast
@@ -254,4 +342,13 @@ func (s *Capture) Set() {
 	s.BroadcastPipeline = viper.GetString("broadcast_pipeline")
 	s.BroadcastUrl = viper.GetString("broadcast_url")
 	s.BroadcastAutostart = viper.GetBool("broadcast_autostart")
+
+	//
+	// stream pipelines
+	//
+
+	s.PipelineRestartAttempts = viper.GetInt("pipeline_restart_attempts")
+	s.SampleBufferSize = viper.GetInt("sample_buffer_size")
+	s.SampleStallTimeout = time.Duration(viper.GetInt("sample_stall_timeout")) * time.Second
+	s.ScreenshotMaxAge = time.Duration(viper.GetInt("screenshot_max_age")) * time.Millisecond
 }
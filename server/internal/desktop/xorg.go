@@ -86,6 +86,10 @@ func (manager *DesktopManagerCtx) GetScreenSize() *types.ScreenSize {
 	return xorg.GetScreenSize()
 }
 
+func (manager *DesktopManagerCtx) DisplayExists(display string) bool {
+	return xorg.DisplayExists(display)
+}
+
 func (manager *DesktopManagerCtx) SetKeyboardMap(kbd types.KeyboardMap) error {
 	// TOOD: Use native API.
 	cmd := exec.Command("setxkbmap", "-layout", kbd.Layout, "-variant", kbd.Variant)
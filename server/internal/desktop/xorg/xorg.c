@@ -15,6 +15,15 @@ void XDisplayClose(void) {
   XCloseDisplay(DISPLAY);
 }
 
+int XDisplayExists(char *name) {
+  // separate connection, so that the main one is not affected
+  Display *display = XOpenDisplay(name);
+  if (display == NULL) return 0;
+
+  XCloseDisplay(display);
+  return 1;
+}
+
 void XMove(int x, int y) {
   Display *display = getXDisplay();
   XWarpPointer(display, None, DefaultRootWindow(display), 0, 0, 0, 0, x, y);
@@ -51,6 +51,14 @@ func DisplayOpen(display string) bool {
 	return int(ok) == 1
 }
 
+// checks that display and its screen can be opened, e.g. :0.1
+func DisplayExists(display string) bool {
+	displayUnsafe := C.CString(display)
+	defer C.free(unsafe.Pointer(displayUnsafe))
+
+	return int(C.XDisplayExists(displayUnsafe)) == 1
+}
+
 func DisplayClose() {
 	mu.Lock()
 	defer mu.Unlock()
@@ -14,6 +14,7 @@ extern void goSetScreenRates(int index, int rate_index, short rate);
 Display *getXDisplay(void);
 int XDisplayOpen(char *input);
 void XDisplayClose(void);
+int XDisplayExists(char *name);
 
 void XMove(int x, int y);
 void XCursorPosition(int *x, int *y);
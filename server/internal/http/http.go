@@ -30,7 +30,7 @@ type Server struct {
 	conf   *config.Server
 }
 
-func New(conf *config.Server, webSocketHandler types.WebSocketHandler, desktop types.DesktopManager) *Server {
+func New(conf *config.Server, webSocketHandler types.WebSocketHandler, desktop types.DesktopManager, capture types.CaptureManager) *Server {
 	logger := log.With().Str("module", "http").Logger()
 
 	router := chi.NewRouter()
@@ -85,6 +85,26 @@ func New(conf *config.Server, webSocketHandler types.WebSocketHandler, desktop t
 		}
 	})
 
+	router.Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		password := r.URL.Query().Get("pwd")
+		isAdmin, err := webSocketHandler.IsAdmin(password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		if !isAdmin {
+			http.Error(w, "bad authorization", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		if err := capture.WriteMetrics(w); err != nil {
+			logger.Warn().Err(err).Msg("failed writing metrics")
+		}
+	})
+
 	router.Get("/screenshot.jpg", func(w http.ResponseWriter, r *http.Request) {
 		password := r.URL.Query().Get("pwd")
 		isAdmin, err := webSocketHandler.IsAdmin(password)
@@ -118,6 +138,39 @@ func New(conf *config.Server, webSocketHandler types.WebSocketHandler, desktop t
 		}
 	})
 
+	router.Get("/screenshot.png", func(w http.ResponseWriter, r *http.Request) {
+		password := r.URL.Query().Get("pwd")
+		isAdmin, err := webSocketHandler.IsAdmin(password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		if !isAdmin {
+			http.Error(w, "bad authorization", http.StatusUnauthorized)
+			return
+		}
+
+		if webSocketHandler.IsLocked("login") {
+			http.Error(w, "room is locked", http.StatusLocked)
+			return
+		}
+
+		// taken from capture pipeline, recent screenshots are shared between requests
+		data, err := capture.Video().ScreenshotPNG()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Header().Set("Content-Type", "image/png")
+
+		if _, err := w.Write(data); err != nil {
+			logger.Warn().Err(err).Msg("failed writing screenshot")
+		}
+	})
+
 	// allow downloading and uploading files
 	if webSocketHandler.FileTransferEnabled() {
 		router.Get("/file", func(w http.ResponseWriter, r *http.Request) {
@@ -205,6 +258,16 @@ func New(conf *config.Server, webSocketHandler types.WebSocketHandler, desktop t
 		_, _ = w.Write([]byte("true"))
 	})
 
+	// fails when capture pipeline is wedged, so that container can be restarted
+	router.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !capture.IsHealthy() {
+			http.Error(w, "false", http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = w.Write([]byte("true"))
+	})
+
 	fs := http.FileServer(http.Dir(conf.Static))
 	router.Get("/*", func(w http.ResponseWriter, r *http.Request) {
 		if _, err := os.Stat(conf.Static + r.URL.Path); !os.IsNotExist(err) {
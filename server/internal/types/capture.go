@@ -1,15 +1,229 @@
 package types
 
 import (
+	"context"
 	"errors"
+	"io"
+	"sync/atomic"
+	"time"
 
 	"m1k1o/neko/internal/types/codec"
 )
 
 var (
 	ErrCapturePipelineAlreadyExists = errors.New("capture pipeline already exists")
+	// stream already has maximum number of listeners
+	ErrTooManyListeners = errors.New("too many listeners")
+	// pipeline string is invalid or does not contain expected elements
+	ErrPipelineParse = errors.New("invalid capture pipeline")
+	// required plugin or device is missing or busy
+	ErrPipelineResource = errors.New("capture pipeline resource unavailable")
+	// pipeline could not change its state
+	ErrPipelinePlay = errors.New("unable to play capture pipeline")
 )
 
+// running capture pipeline, implemented by gst.Pipeline
+type Pipeline interface {
+	Src() string
+	SetSrc(src string)
+	State() string
+	Errors() <-chan error
+	Playing() <-chan struct{}
+	Destroyed() <-chan struct{}
+
+	AttachAppsink(sinkName string, sampleChannel chan Sample) error
+	SetLatestOnly(enabled bool)
+	SetLeaseBuffers(enabled bool)
+	SetDroppedCounter(counter *atomic.Uint64)
+	Play() error
+	Pause() error
+	Destroy()
+
+	SetPropInt(binName string, prop string, value int) bool
+	SetPropString(binName string, prop string, value string) bool
+	GetPropString(binName string, prop string) (string, bool)
+	GetPadCaps(binName string, padName string) (string, bool)
+	SetCapsFramerate(binName string, numerator, denominator int) bool
+	SetCapsResolution(binName string, width, height int) bool
+	ForceKeyUnit() bool
+
+	EndBranch(srcName string, sinkName string, timeout time.Duration) error
+	EndOfStream(timeout time.Duration) error
+	QueryBytes(binName string) (int64, bool)
+	QueryLatency() (time.Duration, bool)
+	RebaseTimestamps(binName string) bool
+	DumpDot() (string, bool)
+}
+
+// how encoder distributes bitrate over time
+type RateControlMode string
+
+const (
+	// constant bitrate, predictable bandwidth
+	RateControlCBR RateControlMode = "cbr"
+	// variable bitrate, better quality for the same average bitrate
+	RateControlVBR RateControlMode = "vbr"
+)
+
+type StreamSinkStatus struct {
+	Running       bool
+	State         string // NULL, READY, PAUSED or PLAYING
+	ListenerCount int
+	Codec         string
+	Framerate     int16
+	LastError     error
+
+	SampleBufferLength   int
+	SampleBufferCapacity int
+}
+
+type PipelineEventType string
+
+const (
+	PipelineEventCreated   PipelineEventType = "created"
+	PipelineEventPlaying   PipelineEventType = "playing"
+	PipelineEventError     PipelineEventType = "error"
+	PipelineEventDestroyed PipelineEventType = "destroyed"
+)
+
+type PipelineEvent struct {
+	Type  PipelineEventType
+	Time  time.Time
+	Codec string
+	Error error // set for error events only
+}
+
+type BranchRole string
+
+const (
+	BranchRoleWebRTC    BranchRole = "webrtc"
+	BranchRoleBroadcast BranchRole = "broadcast"
+	BranchRoleRecord    BranchRole = "record"
+	BranchRoleHLS       BranchRole = "hls"
+)
+
+type BranchInfo struct {
+	Role   BranchRole
+	Sink   string // name of the sink element
+	Layer  string // quality layer of webrtc branch
+	Target string // url, file or directory the branch writes to
+	State  string
+	Bytes  int64 // passed to the sink, -1 if it cannot tell
+}
+
+type StreamBroadcastStatus struct {
+	Active     bool
+	Running    bool
+	Connected  bool   // connection is up, lost one is reestablished by restarting pipeline
+	Protocol   string // rtmp or srt
+	Url        string
+	BitrateCap int // kbit/s, zero if not limited
+	LastError  error
+}
+
+type StreamRecordingStatus struct {
+	Active bool
+	// pipeline is writing to the last file, false while it is being recreated
+	Running bool
+	Path    string
+	Format  string // webm or mp4
+	// segments written so far, new one is started when pipeline is recreated
+	Files        []string
+	BytesWritten int64
+	LastError    error
+}
+
+// tunables and runtime state of stream sink, field names match logger context
+type StreamSinkConfig struct {
+	VideoID           string   `json:"video_id"`
+	Codec             string   `json:"codec"`
+	Layers            []string `json:"layers,omitempty"`
+	Framerate         int16    `json:"framerate"`
+	AdaptiveFramerate bool     `json:"adaptive_framerate"`
+	MinFramerate      int16    `json:"min_framerate,omitempty"`
+	MaxFramerate      int16    `json:"max_framerate,omitempty"`
+	Bitrate           int      `json:"bitrate"` // kbit/s
+	RateControl       string   `json:"rate_control,omitempty"`
+	EncoderPreset     string   `json:"encoder_preset,omitempty"`
+	IntraRefresh      bool     `json:"intra_refresh"`
+	KeyframeInterval  int      `json:"keyframe_interval,omitempty"` // frames
+	Transform         string   `json:"transform,omitempty"`
+	QPMin             int      `json:"qp_min,omitempty"`
+	QPMax             int      `json:"qp_max,omitempty"`
+	QueueLeaky        string   `json:"queue_leaky,omitempty"`
+	QueueMaxBuffers   int      `json:"queue_max_buffers,omitempty"`
+	Width             int      `json:"width,omitempty"`
+	Height            int      `json:"height,omitempty"`
+	Display           string   `json:"display,omitempty"`
+	AudioSource       string   `json:"audio_source,omitempty"`
+	CursorVisible     *bool    `json:"cursor_visible,omitempty"`
+	PauseOnIdle       bool     `json:"pause_on_idle"`
+	AlwaysOn          bool     `json:"always_on"`
+	Placeholder       bool     `json:"placeholder"`
+	RTPOutput         bool     `json:"rtp_output"`
+
+	State        string  `json:"state"`
+	Paused       bool    `json:"paused"`
+	Prewarmed    bool    `json:"prewarmed"`
+	Listeners    int     `json:"listeners"`
+	MaxListeners int     `json:"max_listeners"`
+	Uptime       float64 `json:"uptime"` // seconds
+	RestartCount int     `json:"restart_count"`
+	Broadcasting bool    `json:"broadcasting"`
+	Recording    bool    `json:"recording"`
+	HLS          bool    `json:"hls"`
+}
+
+type FrameSizeBucket struct {
+	UpperBound int // bytes, inclusive
+	Count      int
+}
+
+type StreamFrameStats struct {
+	Frames          int
+	Keyframes       int
+	AvgFrameSize    int // bytes
+	MaxFrameSize    int
+	AvgKeyframeSize int
+	MaxKeyframeSize int
+	AvgDeltaSize    int
+	// average number of frames between keyframes, zero if less than two keyframes were seen
+	KeyframeInterval float64
+	Histogram        []FrameSizeBucket
+}
+
+type StreamListenerStats struct {
+	Layer            string
+	DeliveredSamples uint64
+	DroppedSamples   uint64
+	DeliveredBytes   uint64
+	// samples (or batches for batch subscribers) waiting in subscriber buffer,
+	// growing value means it is lagging
+	Buffered int
+}
+
+type ListenerJoinLatency struct {
+	JoinedAt time.Time
+	// time from AddListener to the first sample emitted after it
+	Latency time.Duration
+}
+
+type StreamJoinLatencyStats struct {
+	Joins []ListenerJoinLatency // oldest first
+	Min   time.Duration
+	Max   time.Duration
+	Avg   time.Duration
+	P95   time.Duration
+}
+
+type StreamHLSStatus struct {
+	Active          bool
+	Running         bool
+	Dir             string
+	Playlist        string
+	SegmentDuration time.Duration
+}
+
 type BroadcastManager interface {
 	Start(url string) error
 	Stop()
@@ -17,20 +231,134 @@ type BroadcastManager interface {
 	Url() string
 }
 
-type StreamSinkManager interface {
+// encoded samples of a stream, so that any transport, e.g. webrtc, can consume
+// them the same way
+type SampleSource interface {
 	Codec() codec.RTPCodec
+	Subscribe(id string) (<-chan Sample, error)
+	Unsubscribe(id string)
+}
+
+type StreamSinkManager interface {
+	SampleSource
+
+	ClockRate() uint32
+	PayloadType() uint8
+	Timebase() (numerator uint32, denominator uint32)
 
 	AddListener() error
+	AddListenerCtx(ctx context.Context) error
 	RemoveListener() error
 
 	ListenersCount() int
+	SetMaxListeners(n int)
 	Started() bool
-	GetSampleChannel() chan Sample
+	OnListenerChange(handler func(count int))
+	WaitForFirstSample(timeout time.Duration) error
+	LastSampleTime() time.Time
+	OnPlaying(handler func())
+	WaitForPlaying(timeout time.Duration) error
+	Pause() error
+	Resume() error
+	SetPauseOnIdle(enabled bool)
+	SetLogLevel(level string) error
+	SetAlwaysOn(enabled bool) error
+	AlwaysOn() bool
+	SetLatestOnly(enabled bool)
+	SetLeaseBuffers(enabled bool)
+
+	Prewarm(timeout time.Duration) error
+	Cooldown()
+	Prewarmed() bool
+	Layers() []string
+	SetAppsinkName(layer string, name string) error
+	RegisterSampleHook(hook func(Sample) (Sample, bool))
+	Events() <-chan PipelineEvent
+	RealizedBitrate() int
+	IsHealthy() bool
+	SetRTPOutput(enabled bool) error
+	Reset() error
+	RTPOutput() bool
+	SetPlaceholder(enabled bool) error
+	SetQueueLeaky(mode string) error
+	SetQueueMaxBuffers(maxBuffers int) error
+	Placeholder() bool
+	SetAudioSource(name string) error
+	AudioSource() string
+	SubscribeLayer(id string, layer string) (<-chan Sample, error)
+	SubscribeBatch(id string, maxSamples int, maxDelay time.Duration) (<-chan []Sample, error)
+	SetLayer(id string, layer string) error
+	ListenerStats(id string) (StreamListenerStats, error)
+	Flush()
+	DroppedSamples() uint64
+	FrameStats() StreamFrameStats
+	JoinLatencies() StreamJoinLatencyStats
+	SampleBufferUsage() (length int, capacity int)
+	Status() StreamSinkStatus
+	Config() StreamSinkConfig
+	LastError() error
+	Uptime() time.Duration
+	RestartCount() int
+	LastRestart() time.Time
+
+	SetBitrate(kbps int) error
+	SetRateControl(mode RateControlMode) error
+	SetEncoderPreset(preset string) error
+	SetIntraRefresh(enabled bool) error
+	SetKeyframeInterval(frames int) error
+	SetTransform(method string) error
+	SetQP(min, max int) error
+	Transform() string
+	GetBitrate() int
+	SetChangeFramerate(rate int16)
+	SetAdaptiveFramerate(allow bool)
+	SupportsAdaptiveFramerate() bool
+	SetFramerateBounds(min, max int16) error
+	SetAdaptiveLimits(minFramerate, maxFramerate int16, minBitrate, maxBitrate int) error
+	SetAdaptiveParams(framerateStep int16, cooldown time.Duration) error
+	SetTargetBandwidth(bps int) error
+	GetFramerate() int16
+	SetResolution(width, height int) error
+	SetCursorVisible(visible bool) error
+	SetCaptureRegion(x, y, width, height int) error
+	SetDisplay(display string) error
+	SetDisplayResolution(width, height int) error
+	Display() string
+	Screenshot() ([]byte, error)
+	ScreenshotPNG() ([]byte, error)
+	SetScreenshotMaxAge(maxAge time.Duration) error
+	ForceKeyframe() error
+	NegotiatedCaps() (string, error)
+	Latency() (time.Duration, error)
+	DumpPipelineDOT() (string, error)
+	ValidatePipeline(pipelineStr string) error
+	GetElementProperty(element string, prop string) (string, error)
+	SetElementProperty(element string, prop string, value interface{}) error
+
+	StartBroadcast(url string) error
+	StartBroadcastSRT(url string) error
+	StopBroadcast() error
+	Broadcasting() bool
+	BroadcastStatus() StreamBroadcastStatus
+	SetBroadcastBitrateCap(kbps int) error
+	Branches() []BranchInfo
+
+	StartRecording(path string, format string) error
+	StopRecording() error
+	SetRecordingRebase(enabled bool)
+	Recording() bool
+	RecordingStatus() StreamRecordingStatus
+
+	StartHLS(dir string, segmentDuration time.Duration) error
+	StopHLS() error
+	HLSStatus() StreamHLSStatus
 }
 
 type CaptureManager interface {
 	Start()
 	Shutdown() error
+	WriteMetrics(w io.Writer) error
+	IsHealthy() bool
 
 	Broadcast() BroadcastManager
 	Audio() StreamSinkManager
@@ -31,6 +31,8 @@ func ParseStr(codecName string) (codec RTPCodec, ok bool) {
 		codec = VP8()
 	case VP9().Name:
 		codec = VP9()
+	case VP9SVC().Name:
+		codec = VP9SVC()
 	case AV1().Name:
 		codec = AV1()
 	case H264().Name:
@@ -43,6 +45,8 @@ func ParseStr(codecName string) (codec RTPCodec, ok bool) {
 		codec = PCMU()
 	case PCMA().Name:
 		codec = PCMA()
+	case AAC().Name:
+		codec = AAC()
 	default:
 		ok = false
 	}
@@ -103,6 +107,13 @@ func VP9() RTPCodec {
 	}
 }
 
+// VP9 with temporal scalability, negotiated as plain VP9
+func VP9SVC() RTPCodec {
+	codec := VP9()
+	codec.Name = "vp9-svc"
+	return codec
+}
+
 // TODO: Profile ID.
 func H264() RTPCodec {
 	return RTPCodec{
@@ -194,3 +205,19 @@ func PCMA() RTPCodec {
 		},
 	}
 }
+
+// not supported by browsers over webrtc, used for broadcast and hls outputs
+func AAC() RTPCodec {
+	return RTPCodec{
+		Name:        "aac",
+		PayloadType: 97,
+		Type:        webrtc.RTPCodecTypeAudio,
+		Capability: webrtc.RTPCodecCapability{
+			MimeType:     "audio/MP4A-LATM",
+			ClockRate:    48000,
+			Channels:     2,
+			SDPFmtpLine:  "",
+			RTCPFeedback: []webrtc.RTCPFeedback{},
+		},
+	}
+}
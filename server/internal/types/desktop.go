@@ -63,6 +63,7 @@ type DesktopManager interface {
 	ScreenConfigurations() map[int]ScreenConfiguration
 	SetScreenSize(ScreenSize) error
 	GetScreenSize() *ScreenSize
+	DisplayExists(display string) bool
 	SetKeyboardMap(KeyboardMap) error
 	GetKeyboardMap() (*KeyboardMap, error)
 	SetKeyboardModifiers(mod KeyboardModifiers)
@@ -1,11 +1,60 @@
 package types
 
 import (
+	"time"
+
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
 )
 
-type Sample media.Sample
+type Sample struct {
+	media.Sample
+
+	// temporal layer of the frame when using scalable coding, zero is the base
+	// layer that all higher layers depend on
+	TemporalLayer uint8
+
+	// presentation timestamp relative to pipeline start, negative if unknown
+	PTS time.Duration
+
+	// frame can be decoded without previous frames, always true for audio
+	Keyframe bool
+
+	// frame is not captured content, but placeholder sent until capture starts
+	Placeholder bool
+
+	// Data is rtp packet produced by the pipeline instead of encoded frame,
+	// marker and timestamp are taken from its header
+	RTP          bool
+	Marker       bool
+	RTPTimestamp uint32
+
+	// set when Data is not copied, but references memory of the pipeline. Data
+	// is valid only until the sample is released. whoever receives a sample owns
+	// one reference and must release it exactly once, also when it is dropped.
+	// passing sample to another owner requires Retain for each of them.
+	Lease SampleLease
+}
+
+// reference counted pipeline memory backing sample data
+type SampleLease interface {
+	Retain()
+	Release()
+}
+
+// adds reference for another owner, no-op if data is not leased
+func (sample Sample) Retain() {
+	if sample.Lease != nil {
+		sample.Lease.Retain()
+	}
+}
+
+// releases owned reference, no-op if data is not leased
+func (sample Sample) Release() {
+	if sample.Lease != nil {
+		sample.Lease.Release()
+	}
+}
 
 type WebRTCManager interface {
 	Start()
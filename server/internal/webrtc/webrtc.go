@@ -12,7 +12,6 @@ import (
 	"github.com/pion/ice/v2"
 	"github.com/pion/interceptor"
 	"github.com/pion/webrtc/v3"
-	"github.com/pion/webrtc/v3/pkg/media"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
@@ -55,20 +54,9 @@ func (manager *WebRTCManager) Start() {
 		manager.logger.Panic().Err(err).Msg("unable to create audio track")
 	}
 
-	go func() {
-		for {
-			sample, ok := <-manager.capture.Audio().GetSampleChannel()
-			if !ok {
-				manager.logger.Debug().Msg("audio capture channel is closed")
-				continue
-			}
-
-			err := manager.audioTrack.WriteSample(media.Sample(sample))
-			if err != nil && errors.Is(err, io.ErrClosedPipe) {
-				manager.logger.Warn().Err(err).Msg("audio pipeline failed to write")
-			}
-		}
-	}()
+	if err := manager.writeSamples("audio", manager.capture.Audio(), manager.audioTrack); err != nil {
+		manager.logger.Panic().Err(err).Msg("unable to subscribe to audio samples")
+	}
 
 	//
 	// video
@@ -80,20 +68,9 @@ func (manager *WebRTCManager) Start() {
 		manager.logger.Panic().Err(err).Msg("unable to create video track")
 	}
 
-	go func() {
-		for {
-			sample, ok := <-manager.capture.Video().GetSampleChannel()
-			if !ok {
-				manager.logger.Debug().Msg("video capture channel is closed")
-				continue
-			}
-
-			err := manager.videoTrack.WriteSample(media.Sample(sample))
-			if err != nil && errors.Is(err, io.ErrClosedPipe) {
-				manager.logger.Warn().Err(err).Msg("video pipeline failed to write")
-			}
-		}
-	}()
+	if err := manager.writeSamples("video", manager.capture.Video(), manager.videoTrack); err != nil {
+		manager.logger.Panic().Err(err).Msg("unable to subscribe to video samples")
+	}
 
 	//
 	// api
@@ -111,8 +88,50 @@ func (manager *WebRTCManager) Start() {
 		Msgf("webrtc starting")
 }
 
+// writes samples of the source to the track until the source is unsubscribed,
+// rtp packets produced by the pipeline are skipped, because the track would
+// packetize them again
+func (manager *WebRTCManager) writeSamples(kind string, source types.SampleSource, track *webrtc.TrackLocalStaticSample) error {
+	samples, err := source.Subscribe("webrtc")
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		// warned once until pipeline produces samples again
+		skipping := false
+
+		for sample := range samples {
+			if sample.RTP {
+				sample.Release()
+				if !skipping {
+					skipping = true
+					manager.logger.Warn().Msgf("%s pipeline produces rtp packets, they are skipped", kind)
+				}
+				continue
+			}
+			skipping = false
+
+			err := track.WriteSample(sample.Sample)
+			// packetizer copies data, so it can be released right away
+			sample.Release()
+			if err != nil && errors.Is(err, io.ErrClosedPipe) {
+				manager.logger.Warn().Err(err).Msgf("%s pipeline failed to write", kind)
+			}
+		}
+
+		manager.logger.Debug().Msgf("%s capture channel is closed", kind)
+	}()
+
+	return nil
+}
+
 func (manager *WebRTCManager) Shutdown() error {
 	manager.logger.Info().Msgf("webrtc shutting down")
+
+	manager.capture.Audio().Unsubscribe("webrtc")
+	manager.capture.Video().Unsubscribe("webrtc")
+
 	return nil
 }
 
@@ -263,6 +282,12 @@ func (manager *WebRTCManager) CreatePeer(id string, session types.Session) (type
 			if err = session.SetConnected(true); err != nil {
 				manager.logger.Warn().Err(err).Msg("unable to set connected on peer")
 				manager.sessions.Destroy(id)
+				return
+			}
+
+			// do not let new peer wait for next keyframe
+			if err := manager.capture.Video().ForceKeyframe(); err != nil {
+				manager.logger.Warn().Err(err).Msg("unable to force keyframe for new peer")
 			}
 		}
 	})
@@ -0,0 +1,103 @@
+package webrtc
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/rs/zerolog"
+
+	"m1k1o/neko/internal/types"
+	"m1k1o/neko/internal/types/codec"
+)
+
+type testSource struct {
+	samples chan types.Sample
+}
+
+func (source *testSource) Codec() codec.RTPCodec {
+	return codec.VP8()
+}
+
+func (source *testSource) Subscribe(id string) (<-chan types.Sample, error) {
+	return source.samples, nil
+}
+
+func (source *testSource) Unsubscribe(id string) {}
+
+type testLease struct {
+	released *atomic.Int32
+}
+
+func (lease testLease) Retain() {}
+
+func (lease testLease) Release() {
+	lease.released.Add(1)
+}
+
+// log output written by the writing goroutine and read by test
+type testLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (log *testLog) Write(p []byte) (int, error) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	return log.buf.Write(p)
+}
+
+func (log *testLog) String() string {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	return log.buf.String()
+}
+
+// rtp packets are released without being packetized again, skipping is
+// reported once until the pipeline produces samples again
+func TestWriteSamplesSkipsRTP(t *testing.T) {
+	output := &testLog{}
+	manager := &WebRTCManager{logger: zerolog.New(output)}
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &testSource{samples: make(chan types.Sample)}
+	if err := manager.writeSamples("video", source, track); err != nil {
+		t.Fatal(err)
+	}
+
+	var released atomic.Int32
+	sent := 0
+	for _, rtp := range []bool{true, true, false, true, true} {
+		source.samples <- types.Sample{
+			Sample: media.Sample{Data: []byte{0}, Duration: time.Millisecond},
+			RTP:    rtp,
+			Lease:  testLease{released: &released},
+		}
+		sent++
+	}
+	close(source.samples)
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(output.String(), "capture channel is closed") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if count := int(released.Load()); count != sent {
+		t.Fatalf("%d of %d samples were released", count, sent)
+	}
+
+	if warnings := strings.Count(output.String(), "rtp packets, they are skipped"); warnings != 2 {
+		t.Fatalf("skipping was reported %d times, expected 2", warnings)
+	}
+}
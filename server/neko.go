@@ -131,7 +131,7 @@ func (neko *Neko) Start() {
 	webSocketHandler := websocket.New(sessionManager, desktopManager, captureManager, webRTCManager, neko.WebSocket)
 	webSocketHandler.Start()
 
-	server := http.New(neko.Server, webSocketHandler, desktopManager)
+	server := http.New(neko.Server, webSocketHandler, desktopManager, captureManager)
 	server.Start()
 
 	neko.sessionManager = sessionManager